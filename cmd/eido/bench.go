@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// benchCommand compares the CPU used to play a song encoding it with ffmpeg and passing the opus packets through.
+// The song is downloaded before measuring, so only the encoding is counted
+func benchCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	format := fs.String("format", models.DefaultYtdlpFormat, "yt-dlp format used to download the url")
+	bitrate := fs.Int("bitrate", models.FallbackBitrate, "bitrate of the transcoded audio, in kbps")
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("expected a file or a url")
+	}
+
+	data, err := benchInput(fs.Arg(0), *format)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Input: %d KiB\n", len(data)/1024)
+
+	options := *dca.StdEncodeOptions
+	options.RawOutput = true
+	options.Bitrate = *bitrate
+	options.BufferedFrames = models.DefaultBufferedFrames
+
+	paths := []struct {
+		name    string
+		encoder audio.Encoder
+	}{
+		{"transcode", audio.FfmpegEncoder{}},
+		{"passthrough", audio.PassthroughEncoder{Fallback: audio.FfmpegEncoder{}}},
+	}
+
+	for _, path := range paths {
+		pipeline := &audio.Pipeline{
+			Source: audio.ReaderSource(func(ctx context.Context, url string) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			}),
+			Encoder:     path.encoder,
+			BufferBytes: models.DefaultStreamBufferKB * 1024,
+		}
+
+		frames, wall, eido, ffmpeg, err := benchPipeline(pipeline, &options)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path.name, err)
+		}
+
+		audioLength := time.Duration(frames) * 20 * time.Millisecond
+		cpu := eido + ffmpeg
+		fmt.Printf("%-12s %6d frames (%s of audio) in %s, CPU %s = eido %s + ffmpeg %s (%.2f%% of the audio length)\n",
+			path.name, frames, audioLength.Round(time.Second), wall.Round(time.Millisecond),
+			cpu.Round(time.Millisecond), eido.Round(time.Millisecond), ffmpeg.Round(time.Millisecond),
+			100*cpu.Seconds()/max(audioLength.Seconds(), 0.001))
+	}
+
+	fmt.Println("If both paths use the same CPU, the input can't be passed through (not opus, or not 48 kHz with 20 ms frames)")
+	return nil
+}
+
+// benchInput reads the file, or downloads the url with yt-dlp
+func benchInput(input string, format string) (data []byte, err error) {
+	if !strings.Contains(input, "://") {
+		return os.ReadFile(input)
+	}
+
+	fmt.Println("Downloading " + input + "...")
+	return exec.Command("yt-dlp", "-f", format, "-o", "-", input).Output()
+}
+
+// benchPipeline reads all the frames of the song as fast as possible,
+// and returns how many there were, the time it took and the CPU used by eido and by ffmpeg, from rusage
+func benchPipeline(pipeline *audio.Pipeline, options *dca.EncodeOptions) (frames int, wall time.Duration, eido time.Duration, ffmpeg time.Duration, err error) {
+	startSelf, startChildren := cpuTime()
+	start := time.Now()
+
+	stream, err := pipeline.Open("bench", options)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+
+	for {
+		_, err = stream.OpusFrame()
+		if err != nil {
+			break
+		}
+		frames++
+	}
+	stream.Close()
+
+	if err != io.EOF {
+		return frames, 0, 0, 0, err
+	}
+	if frames == 0 {
+		return 0, 0, 0, 0, errors.New("no audio produced, check that ffmpeg is installed and the input is audio")
+	}
+	self, children := cpuTime()
+	return frames, time.Since(start), self - startSelf, children - startChildren, nil
+}
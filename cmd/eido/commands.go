@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/matthew-balzan/eido/internal/bot"
+	"github.com/matthew-balzan/eido/internal/commands"
+	"github.com/matthew-balzan/eido/internal/vars"
+)
+
+// commandsCommand syncs, lists or deletes the slash commands without starting the bot.
+// By default it works on the scopes used by `run`, the flags select a single guild or the global scope
+func commandsCommand(args []string) (err error) {
+	if len(args) == 0 {
+		return errors.New("expected sync, list or purge")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("commands "+action, flag.ContinueOnError)
+	guild := fs.String("guild", "", "work only on the commands of this guild")
+	global := fs.Bool("global", false, "work only on the global commands")
+	if err = parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	scopes := bot.CommandScopes(vars.Config())
+	switch {
+	case *guild != "":
+		scopes = []string{*guild}
+	case *global:
+		scopes = []string{""}
+	}
+
+	session, err := discordgo.New(vars.Config().DiscordToken)
+	if err != nil {
+		return err
+	}
+
+	app, err := session.Application("@me")
+	if err != nil {
+		return fmt.Errorf("cannot get the application, check the token: %w", err)
+	}
+
+	for _, guildId := range scopes {
+		switch action {
+		case "sync":
+			err = bot.SyncCommands(session, app.ID, guildId, commands.ApplicationCommands())
+		case "list":
+			err = listCommands(session, app.ID, guildId)
+		case "purge":
+			err = bot.PurgeCommands(session, app.ID, guildId)
+		default:
+			return errors.New("unknown action \"" + action + "\", expected sync, list or purge")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listCommands prints the commands registered in the scope
+func listCommands(session *discordgo.Session, appId string, guildId string) (err error) {
+	remote, err := session.ApplicationCommands(appId, guildId)
+	if err != nil {
+		return err
+	}
+
+	if guildId == "" {
+		fmt.Println("Global commands:")
+	} else {
+		fmt.Println("Commands of guild " + guildId + ":")
+	}
+	if len(remote) == 0 {
+		fmt.Println("  (none)")
+	}
+	for _, c := range remote {
+		fmt.Printf("  /%s - %s\n", c.Name, c.Description)
+	}
+	return nil
+}
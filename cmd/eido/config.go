@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// configCommand checks the config, with the flags applied, without starting the bot
+func configCommand(args []string) (err error) {
+	if len(args) == 0 || args[0] != "validate" {
+		return errors.New("expected validate")
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	if err = parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	if err = requireConfig(); err != nil {
+		return err
+	}
+
+	fmt.Println("Config is valid")
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// cpuTime is not available on this system, the benchmark shows only the elapsed time
+func cpuTime() (self time.Duration, children time.Duration) {
+	return 0, 0
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// cpuTime returns the CPU used so far by the process, and by its child processes that ended, ex. ffmpeg
+func cpuTime() (self time.Duration, children time.Duration) {
+	var selfUsage, childrenUsage syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &selfUsage)
+	syscall.Getrusage(syscall.RUSAGE_CHILDREN, &childrenUsage)
+
+	return rusageTime(selfUsage), rusageTime(childrenUsage)
+}
+
+func rusageTime(r syscall.Rusage) time.Duration {
+	return time.Duration(r.Utime.Nano() + r.Stime.Nano())
+}
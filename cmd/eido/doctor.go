@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/matthew-balzan/eido/internal/utils"
+	"github.com/matthew-balzan/eido/internal/vars"
+)
+
+// doctorCommand checks the environment needed to play music, and prints the result of each check
+func doctorCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	if err = parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	checks := []struct {
+		name  string
+		check func() (string, error)
+	}{
+		{"ffmpeg", func() (string, error) { return programVersion("ffmpeg", "-version") }},
+		{"yt-dlp", func() (string, error) { return programVersion("yt-dlp", "--version") }},
+		{"config", func() (string, error) { return "valid", utils.ValidateConfig(vars.Config()) }},
+		{"discord token", checkToken},
+	}
+
+	failed := false
+	for _, c := range checks {
+		result, err := c.check()
+		if err != nil {
+			failed = true
+			fmt.Printf("[FAIL] %s: %s\n", c.name, err)
+			continue
+		}
+		fmt.Printf("[ OK ] %s: %s\n", c.name, result)
+	}
+
+	if failed {
+		return errors.New("some checks failed")
+	}
+	return nil
+}
+
+// programVersion returns the first line printed by the program when asked for its version
+func programVersion(name string, arg string) (version string, err error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("not installed or not in the PATH")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, arg).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s is not working: %w", path, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Scan()
+	return scanner.Text(), nil
+}
+
+// checkToken returns the name of the bot the token belongs to
+func checkToken() (name string, err error) {
+	if vars.Config().DiscordToken == "" {
+		return "", errors.New("DISCORD_TOKEN is missing")
+	}
+
+	session, err := discordgo.New(vars.Config().DiscordToken)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := session.User("@me")
+	if err != nil {
+		return "", fmt.Errorf("rejected by discord: %w", err)
+	}
+	return "logged in as " + user.Username, nil
+}
@@ -1,46 +1,152 @@
 package main
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
 
-	"github.com/bwmarrin/discordgo"
+	"github.com/spf13/viper"
 
-	"github.com/matthew-balzan/eido/internal/bot"
 	"github.com/matthew-balzan/eido/internal/utils"
 	"github.com/matthew-balzan/eido/internal/vars"
 )
 
+const usage = `Usage: eido <command> [flags]
+
+Commands:
+  run                       start the bot (default)
+  commands sync|list|purge  manage the slash commands registered on discord
+  config validate           check the config and print the problems found
+  doctor                    check that ffmpeg, yt-dlp and the token work
+  bench <file or url>       compare the CPU used encoding a song and passing it through
+  state export [file]       print or save the settings of the servers
+  state import <file>       replace the settings of the servers, with the bot stopped
+
+Run "eido <command> -h" for the flags of a command`
+
+// subcommand runs with the arguments that follow its name
+type subcommand func(args []string) error
+
+var subcommands = map[string]subcommand{
+	"run":      runCommand,
+	"commands": commandsCommand,
+	"config":   configCommand,
+	"doctor":   doctorCommand,
+	"bench":    benchCommand,
+	"state":    stateCommand,
+}
+
 func main() {
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		fmt.Println(usage)
+		return
+	}
 
-	// Load configs
-	_, err := utils.LoadConfig()
+	command, ok := subcommands[name]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Unknown command \""+name+"\"\n\n"+usage)
+		os.Exit(2)
+	}
 
+	err := command(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatal("Cannot load config:", err)
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
+}
 
-	// Create the session
-	dg, err := discordgo.New(vars.Config.DiscordToken)
-	if err != nil {
-		log.Fatalf("Error creating Discord session: %s", err)
-		return
+// configOverrides are the flags that replace a value of the config files
+var configOverrides = []struct{ flag, key, usage string }{
+	{"token", "DISCORD_TOKEN", "discord token, with the \"Bot \" prefix"},
+	{"youtube-key", "YOUTUBE_KEY", "youtube api key"},
+	{"dev-guilds", "DEV_GUILDS", "comma separated guilds where the commands are registered in dev"},
+	{"state-file", "STATE_FILE", "file where the settings of the servers are saved"},
+}
+
+// configFlags are the flags shared by every command that reads the config
+type configFlags struct {
+	path   string
+	env    string
+	values map[string]*string // config key -> value of the flag
+	set    keyValues
+}
+
+// keyValues collects the repeated `-set KEY=VALUE` flags
+type keyValues []string
+
+func (k *keyValues) String() string {
+	return strings.Join(*k, ",")
+}
+
+func (k *keyValues) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return errors.New("expected KEY=VALUE")
+	}
+	*k = append(*k, value)
+	return nil
+}
+
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{values: map[string]*string{}}
+
+	fs.StringVar(&f.path, "config", "", "config file (.env, .yaml, .toml), instead of searching config-<env> in ., the user config folder and /etc/eido")
+	fs.StringVar(&f.env, "env", "", "environment of the config file, \"prod\" or \"dev\" (default $APP_ENV or prod)")
+	for _, o := range configOverrides {
+		f.values[o.key] = fs.String(o.flag, "", o.usage+" (overrides "+o.key+")")
 	}
+	fs.Var(&f.set, "set", "overrides any config `KEY=VALUE`, can be repeated")
 
-	// Initialize the bot
-	bot := bot.NewBot(dg)
+	return f
+}
 
-	// Register
-	bot.RegisterCommands(dg)
-	bot.RegisterHandlers()
+// load reads the config files, with the values of the flags taking precedence
+func (f *configFlags) load(fs *flag.FlagSet) (err error) {
+	if f.env != "" {
+		os.Setenv("APP_ENV", f.env)
+	}
 
-	// Open connection
-	err = dg.Open()
+	fs.Visit(func(fl *flag.Flag) {
+		for _, o := range configOverrides {
+			if o.flag == fl.Name {
+				viper.Set(o.key, *f.values[o.key])
+			}
+		}
+	})
+	for _, pair := range f.set {
+		key, value, _ := strings.Cut(pair, "=")
+		viper.Set(strings.ToUpper(strings.TrimSpace(key)), value)
+	}
+
+	_, err = utils.LoadConfig(f.path)
 	if err != nil {
-		log.Fatalf("Error opening connection: %s", err)
-		return
+		return fmt.Errorf("cannot load config: %w", err)
 	}
+	return nil
+}
 
-	log.Println("Running!")
+// parseFlags parses the flags of the command and loads the config
+func parseFlags(fs *flag.FlagSet, args []string) (err error) {
+	configs := addConfigFlags(fs)
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+	return configs.load(fs)
+}
 
-	bot.WaitForTermination()
+// requireConfig stops the command if the config is not valid
+func requireConfig() (err error) {
+	if err = utils.ValidateConfig(vars.Config()); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	return nil
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/matthew-balzan/eido/internal/bot"
+	"github.com/matthew-balzan/eido/internal/commands"
+	"github.com/matthew-balzan/eido/internal/utils"
+	"github.com/matthew-balzan/eido/internal/vars"
+)
+
+// runCommand starts the bot and waits for a termination signal
+func runCommand(args []string) (err error) {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	if err = parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err = requireConfig(); err != nil {
+		return err
+	}
+	utils.WatchConfig()
+
+	if err = commands.OpenAudioCache(vars.Config()); err != nil {
+		return fmt.Errorf("cannot open the audio cache: %w", err)
+	}
+	if err = commands.OpenYoutubeClient(vars.Config()); err != nil {
+		return fmt.Errorf("cannot create the youtube client: %w", err)
+	}
+
+	// Create the session
+	dg, err := discordgo.New(vars.Config().DiscordToken)
+	if err != nil {
+		return fmt.Errorf("error creating Discord session: %w", err)
+	}
+
+	// Initialize the bot
+	bot := bot.NewBot(dg)
+
+	// Register
+	bot.RegisterCommands(dg)
+	bot.RegisterHandlers()
+	bot.LoadState()
+
+	// Open connection
+	err = dg.Open()
+	if err != nil {
+		return fmt.Errorf("error opening connection: %w", err)
+	}
+
+	log.Println("Running!")
+
+	bot.StartMetricsLog()
+	commands.WarmupCache(vars.Config())
+
+	bot.WaitForTermination()
+	return nil
+}
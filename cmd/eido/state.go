@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/matthew-balzan/eido/internal/commands"
+	"github.com/matthew-balzan/eido/internal/vars"
+)
+
+// stateCommand exports or imports the settings of the servers saved in STATE_FILE.
+// The bot saves the state when it stops, so an import must be done while it's not running
+func stateCommand(args []string) (err error) {
+	if len(args) == 0 {
+		return errors.New("expected export or import")
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("state "+action, flag.ContinueOnError)
+	if err = parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	switch action {
+	case "export":
+		return exportState(fs.Arg(0))
+	case "import":
+		if fs.NArg() == 0 {
+			return errors.New("expected the file to import")
+		}
+		return importState(fs.Arg(0))
+	default:
+		return errors.New("unknown action \"" + action + "\", expected export or import")
+	}
+}
+
+// exportState writes the saved state to the file, or prints it if `path` is empty
+func exportState(path string) (err error) {
+	state, err := commands.ReadStateFile(vars.Config().StateFile)
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		return commands.WriteStateFile(path, state)
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// importState replaces the saved state with the one in the file
+func importState(path string) (err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var state commands.State
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&state); err != nil {
+		return fmt.Errorf("%s is not a valid state: %w", path, err)
+	}
+	for _, guild := range state.Guilds {
+		if guild.GuildId == "" {
+			return fmt.Errorf("%s is not a valid state: a guild has no id", path)
+		}
+	}
+
+	err = commands.WriteStateFile(vars.Config().StateFile, state)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported the settings of %d servers into %s\n", len(state.Guilds), vars.Config().StateFile)
+	return nil
+}
@@ -0,0 +1,107 @@
+package audio
+
+import (
+	"io"
+	"sync"
+)
+
+// Buffer is an in-memory pipe over a ring of fixed size, allocated once.
+// Writes block while the buffer is full, reads block while it's empty,
+// so a slow reader slows down the writer instead of using more memory
+type Buffer struct {
+	lock  sync.Mutex
+	cond  *sync.Cond
+	ring  []byte
+	start int   // position of the first byte to read
+	size  int   // bytes to read
+	err   error // set when closed
+}
+
+func NewBuffer(limit int) *Buffer {
+	b := &Buffer{ring: make([]byte, limit)}
+	b.cond = sync.NewCond(&b.lock)
+	return b
+}
+
+func (b *Buffer) Write(p []byte) (n int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for len(p) > 0 {
+		for b.size == len(b.ring) && b.err == nil {
+			b.cond.Wait()
+		}
+		if b.err != nil {
+			return n, io.ErrClosedPipe
+		}
+
+		end := (b.start + b.size) % len(b.ring)
+		free := len(b.ring) - b.size
+		if end+free > len(b.ring) { // write up to the end of the ring, the rest in the next round
+			free = len(b.ring) - end
+		}
+
+		written := copy(b.ring[end:end+free], p)
+		b.size += written
+		n += written
+		p = p[written:]
+		b.cond.Broadcast()
+	}
+
+	return n, nil
+}
+
+func (b *Buffer) Read(p []byte) (n int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for b.size == 0 && b.err == nil {
+		b.cond.Wait()
+	}
+	if b.size == 0 {
+		return 0, b.err
+	}
+
+	for n < len(p) && b.size > 0 {
+		available := min(b.size, len(b.ring)-b.start)
+		read := copy(p[n:], b.ring[b.start:b.start+available])
+		b.start = (b.start + read) % len(b.ring)
+		b.size -= read
+		n += read
+	}
+	b.cond.Broadcast()
+	return n, nil
+}
+
+// Len returns the bytes waiting to be read
+func (b *Buffer) Len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.size
+}
+
+// Cap returns the size of the buffer
+func (b *Buffer) Cap() int {
+	return len(b.ring)
+}
+
+// CloseWithError closes the buffer: the data left can still be read, then reads return `err` (io.EOF if nil)
+func (b *Buffer) CloseWithError(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil {
+		err = io.EOF
+	}
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+}
+
+// Close closes the buffer, the data left can still be read
+func (b *Buffer) Close() error {
+	b.CloseWithError(io.ErrClosedPipe)
+	return nil
+}
@@ -0,0 +1,317 @@
+package audio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cacheTempPrefix = "tmp-"
+
+// Cache keeps the audio of the sources on disk, up to a size limit.
+// The files are named after the hash of the id of the source, and the least recently used are deleted first
+type Cache struct {
+	dir string
+
+	lock    sync.Mutex
+	limit   int64
+	size    int64
+	entries map[string]*cacheEntry // file name -> entry
+	hits    int64
+	misses  int64
+}
+
+type cacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// OpenCache opens the cache in the folder, creating it if needed, and reads the files already there
+func OpenCache(dir string, limit int64) (cache *Cache, err error) {
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cache = &Cache{dir: dir, limit: limit, entries: map[string]*cacheEntry{}}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), cacheTempPrefix) { // left by a crash
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		cache.entries[file.Name()] = &cacheEntry{size: info.Size(), used: info.ModTime()}
+		cache.size += info.Size()
+	}
+
+	cache.lock.Lock()
+	cache.evict()
+	cache.lock.Unlock()
+
+	return cache, nil
+}
+
+func cacheFileName(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Open returns the audio of the source, false if it's not in the cache
+func (c *Cache) Open(key string) (r io.ReadCloser, res bool) {
+	name := cacheFileName(key)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[name]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	file, err := os.Open(filepath.Join(c.dir, name))
+	if err != nil { // deleted from outside
+		c.remove(name)
+		c.misses++
+		return nil, false
+	}
+
+	c.hits++
+	entry.used = time.Now()
+	os.Chtimes(file.Name(), entry.used, entry.used) // the order of use survives restarts
+	return file, true
+}
+
+// Has returns true if the audio of the source is in the cache
+func (c *Cache) Has(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, ok := c.entries[cacheFileName(key)]
+	return ok
+}
+
+// Create returns a writer for the audio of the source. The audio is added to the cache only when committed
+func (c *Cache) Create(key string) (w *CacheWriter, err error) {
+	file, err := os.CreateTemp(c.dir, cacheTempPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &CacheWriter{cache: c, name: cacheFileName(key), file: file}, nil
+}
+
+// Clear deletes all the audio of the cache
+func (c *Cache) Clear() (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for name := range c.entries {
+		if e := c.remove(name); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// SetLimit changes the size limit, deleting audio if needed
+func (c *Cache) SetLimit(limit int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.limit = limit
+	c.evict()
+}
+
+// CacheStats are the numbers of the cache
+type CacheStats struct {
+	Dir     string
+	Entries int
+	Size    int64
+	Limit   int64
+	Hits    int64
+	Misses  int64
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return CacheStats{Dir: c.dir, Entries: len(c.entries), Size: c.size, Limit: c.limit, Hits: c.hits, Misses: c.misses}
+}
+
+// evict deletes the least recently used audio until the cache fits in the limit. It must be called with the lock
+func (c *Cache) evict() {
+	if c.size <= c.limit {
+		return
+	}
+
+	names := make([]string, 0, len(c.entries))
+	for name := range c.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		return c.entries[names[a]].used.Before(c.entries[names[b]].used)
+	})
+
+	for _, name := range names {
+		if c.size <= c.limit {
+			return
+		}
+		if err := c.remove(name); err != nil {
+			log.Println("ERR: internal/audio/cache.go: Error deleting from the cache - ", err)
+		}
+	}
+}
+
+// remove deletes the audio from the cache. It must be called with the lock
+func (c *Cache) remove(name string) error {
+	entry, ok := c.entries[name]
+	if !ok {
+		return nil
+	}
+	delete(c.entries, name)
+	c.size -= entry.size
+
+	err := os.Remove(filepath.Join(c.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// CacheWriter writes the audio of a source to a temporary file, which becomes part of the cache when committed
+type CacheWriter struct {
+	cache *Cache
+	name  string
+	file  *os.File
+	size  int64
+}
+
+func (w *CacheWriter) Write(p []byte) (n int, err error) {
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Commit adds the audio written to the cache
+func (w *CacheWriter) Commit() (err error) {
+	if err = w.file.Close(); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+
+	c := w.cache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if w.size > c.limit { // it would evict everything and then itself
+		os.Remove(w.file.Name())
+		return nil
+	}
+
+	c.remove(w.name) // written twice at the same time, the last wins
+	if err = os.Rename(w.file.Name(), filepath.Join(c.dir, w.name)); err != nil {
+		os.Remove(w.file.Name())
+		return err
+	}
+
+	now := time.Now()
+	os.Chtimes(filepath.Join(c.dir, w.name), now, now)
+
+	c.entries[w.name] = &cacheEntry{size: w.size, used: now}
+	c.size += w.size
+	c.evict()
+	return nil
+}
+
+// Abort throws away the audio written
+func (w *CacheWriter) Abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// CachedSource reads the audio from the cache when present,
+// otherwise from the source, saving it in the cache if it's read until the end without errors
+type CachedSource struct {
+	Source Source
+	Cache  *Cache
+	Key    func(url string) string // id of the source of the url, an empty string to not cache it
+}
+
+func (c CachedSource) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	key := c.Key(url)
+	if c.Cache == nil || key == "" {
+		return c.Source.Open(ctx, url)
+	}
+
+	if r, ok := c.Cache.Open(key); ok {
+		return r, nil
+	}
+
+	r, err := c.Source.Open(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := c.Cache.Create(key)
+	if err != nil {
+		log.Println("ERR: internal/audio/cache.go: Error writing to the cache - ", err)
+		return r, nil
+	}
+	return &cachingReader{r: r, w: w}, nil
+}
+
+// cachingReader copies what it reads to the cache
+type cachingReader struct {
+	r   io.ReadCloser
+	w   *CacheWriter // nil after an error writing
+	eof bool
+}
+
+func (c *cachingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	if n > 0 && c.w != nil {
+		if _, werr := c.w.Write(p[:n]); werr != nil {
+			log.Println("ERR: internal/audio/cache.go: Error writing to the cache - ", werr)
+			c.w.Abort()
+			c.w = nil
+		}
+	}
+	if err == io.EOF {
+		c.eof = true
+	}
+	return n, err
+}
+
+// Close commits the audio to the cache only if the source ended without errors
+func (c *cachingReader) Close() (err error) {
+	err = c.r.Close()
+	if c.w == nil {
+		return err
+	}
+
+	if c.eof && err == nil {
+		if cerr := c.w.Commit(); cerr != nil {
+			log.Println("ERR: internal/audio/cache.go: Error saving to the cache - ", cerr)
+		}
+	} else {
+		c.w.Abort()
+	}
+	c.w = nil
+	return err
+}
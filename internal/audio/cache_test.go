@@ -0,0 +1,247 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addToCache writes `size` bytes in the cache under the key
+func addToCache(t *testing.T, cache *Cache, key string, size int) {
+	t.Helper()
+
+	w, err := cache.Create(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Write(bytes.Repeat([]byte{1}, size)); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond) // every entry is used at a different time
+}
+
+// tempFiles returns the temporary files left in the folder of the cache
+func tempFiles(t *testing.T, dir string) (names []string) {
+	t.Helper()
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), cacheTempPrefix) {
+			names = append(names, file.Name())
+		}
+	}
+	return names
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache, err := OpenCache(t.TempDir(), 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addToCache(t, cache, "first", 10)
+	addToCache(t, cache, "second", 10)
+
+	// the first song becomes the most recently used
+	r, ok := cache.Open("first")
+	if !ok {
+		t.Fatal("first song not in the cache")
+	}
+	r.Close()
+	time.Sleep(2 * time.Millisecond)
+
+	addToCache(t, cache, "third", 10)
+
+	for key, want := range map[string]bool{"first": true, "second": false, "third": true} {
+		if got := cache.Has(key); got != want {
+			t.Errorf("%s song in the cache: %t, want %t", key, got, want)
+		}
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Size != 20 {
+		t.Errorf("%d songs of %d bytes in the cache, want 2 of 20 bytes", stats.Entries, stats.Size)
+	}
+
+	// bigger than the whole cache, it's not saved
+	addToCache(t, cache, "huge", 30)
+	if cache.Has("huge") || !cache.Has("first") || !cache.Has("third") {
+		t.Error("a song bigger than the limit changed the cache")
+	}
+
+	cache.SetLimit(10)
+	if cache.Has("first") || !cache.Has("third") {
+		t.Error("the lower limit didn't evict the least recently used song")
+	}
+}
+
+func TestCacheOpenAtStartup(t *testing.T) {
+	dir := t.TempDir()
+
+	cache, err := OpenCache(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addToCache(t, cache, "old", 10)
+	addToCache(t, cache, "new", 20)
+
+	// left by a crash while writing
+	if err = os.WriteFile(filepath.Join(dir, cacheTempPrefix+"crash"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = OpenCache(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := cache.Stats(); stats.Entries != 2 || stats.Size != 30 {
+		t.Errorf("%d songs of %d bytes found at startup, want 2 of 30 bytes", stats.Entries, stats.Size)
+	}
+	if !cache.Has("old") || !cache.Has("new") {
+		t.Error("the songs saved before the restart are not in the cache")
+	}
+	if files := tempFiles(t, dir); len(files) != 0 {
+		t.Errorf("temporary files %v not deleted at startup", files)
+	}
+
+	// the order of use survives the restart
+	cache, err = OpenCache(dir, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.Has("old") || !cache.Has("new") {
+		t.Error("opening with a lower limit didn't evict the least recently used song")
+	}
+}
+
+func TestCachedSource(t *testing.T) {
+	audio := bytes.Repeat([]byte("audio"), 100)
+	opened := 0
+	source := CachedSource{
+		Source: ReaderSource(func(ctx context.Context, url string) (io.ReadCloser, error) {
+			opened++
+			return io.NopCloser(bytes.NewReader(audio)), nil
+		}),
+		Key: func(url string) string {
+			if url == "uncached" {
+				return ""
+			}
+			return url + " format"
+		},
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		read   int // bytes read before closing, -1 to read until the end
+		cached bool
+	}{
+		{"read until the end", "full", -1, true},
+		{"closed while reading", "partial", 10, false},
+		{"closed before reading", "unread", 0, false},
+		{"without a key", "uncached", -1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cache, err := OpenCache(dir, 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			source.Cache = cache
+
+			r, err := source.Open(context.Background(), test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.read < 0 {
+				_, err = io.ReadAll(r)
+			} else {
+				_, err = io.ReadFull(r, make([]byte, test.read))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Close()
+
+			if got := cache.Has(test.url + " format"); got != test.cached {
+				t.Fatalf("song in the cache: %t, want %t", got, test.cached)
+			}
+			if files := tempFiles(t, dir); len(files) != 0 {
+				t.Fatalf("temporary files %v left after closing", files)
+			}
+			if !test.cached {
+				return
+			}
+
+			// read again from the cache, without opening the source
+			opened = 0
+			r, err = source.Open(context.Background(), test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(got, audio) || opened != 0 {
+				t.Fatalf("read %d bytes from the cache (%v), opened the source %d times", len(got), err, opened)
+			}
+			if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+				t.Fatalf("%d hits and %d misses, want 1 and 1", stats.Hits, stats.Misses)
+			}
+		})
+	}
+}
+
+// failingReader returns an error after the audio
+type failingReader struct {
+	io.Reader
+}
+
+func (f failingReader) Read(p []byte) (int, error) {
+	n, err := f.Reader.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestCachedSourceError(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := OpenCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := CachedSource{
+		Source: ReaderSource(func(ctx context.Context, url string) (io.ReadCloser, error) {
+			return io.NopCloser(failingReader{strings.NewReader("audio")}), nil
+		}),
+		Cache: cache,
+		Key:   func(url string) string { return url },
+	}
+
+	r, err := source.Open(context.Background(), "song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = io.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	r.Close()
+
+	if cache.Has("song") {
+		t.Fatal("song cached after an error of the source")
+	}
+	if files := tempFiles(t, dir); len(files) != 0 {
+		t.Fatalf("temporary files %v left after the error", files)
+	}
+}
@@ -0,0 +1,330 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+)
+
+// The songs are mixed as pcm: 48 kHz stereo, with 16 bit little endian samples
+const (
+	pcmRate        = 48000
+	pcmChannels    = 2
+	pcmSampleBytes = 2
+	pcmFrameBytes  = pcmChannels * pcmSampleBytes // bytes of one sample of every channel
+)
+
+// PCMBytes returns the size of the duration of pcm audio
+func PCMBytes(duration time.Duration) int {
+	return int(duration.Seconds()*pcmRate) * pcmFrameBytes
+}
+
+func pcmDuration(size int) time.Duration {
+	return time.Duration(size/pcmFrameBytes) * time.Second / pcmRate
+}
+
+// Decoder turns the audio of a source into pcm, applying the start time and the filters of the options.
+// The decoding stops when the context is cancelled
+type Decoder interface {
+	Decode(ctx context.Context, r io.Reader, options *dca.EncodeOptions) (io.ReadCloser, error)
+}
+
+// FfmpegDecoder decodes with ffmpeg
+type FfmpegDecoder struct{}
+
+func (FfmpegDecoder) Decode(ctx context.Context, r io.Reader, options *dca.EncodeOptions) (io.ReadCloser, error) {
+	// like dca, ffmpeg seeks after the filters
+	args := []string{"-loglevel", "error", "-i", "pipe:0", "-map", "0:a", "-ss", strconv.Itoa(options.StartTime)}
+	if options.AudioFilter != "" {
+		args = append(args, "-af", options.AudioFilter)
+	}
+	args = append(args, "-f", "s16le", "-ar", strconv.Itoa(pcmRate), "-ac", strconv.Itoa(pcmChannels), "pipe:1")
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &commandOutput{stdout, cmd}, nil
+}
+
+// pcmOptions returns the options to encode the pcm of the decoder, which already applied the start time and the filters
+func pcmOptions(options *dca.EncodeOptions) *dca.EncodeOptions {
+	pcm := *options
+	pcm.StartTime = 0
+	pcm.AudioFilter = ""
+	pcm.Volume = 1
+	return &pcm
+}
+
+// wavHeader is the header of pcm audio of unknown length, read by ffmpeg until the end of the input
+func wavHeader() []byte {
+	header := make([]byte, 0, 44)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, math.MaxUint32)
+	header = append(header, "WAVEfmt "...)
+	header = binary.LittleEndian.AppendUint32(header, 16)
+	header = binary.LittleEndian.AppendUint16(header, 1) // pcm
+	header = binary.LittleEndian.AppendUint16(header, pcmChannels)
+	header = binary.LittleEndian.AppendUint32(header, pcmRate)
+	header = binary.LittleEndian.AppendUint32(header, pcmRate*pcmFrameBytes)
+	header = binary.LittleEndian.AppendUint16(header, pcmFrameBytes)
+	header = binary.LittleEndian.AppendUint16(header, pcmSampleBytes*8)
+	header = append(header, "data"...)
+	header = binary.LittleEndian.AppendUint32(header, math.MaxUint32-36)
+	return header
+}
+
+// songEnd passes the end of a song to the streams opened after it: the first one to take it mixes it with its start.
+// A stream closed before being played gives the end back, for the stream that replaces it in the queue
+type songEnd struct {
+	lock      sync.Mutex
+	ended     bool
+	done      chan struct{} // closed when the song reached its end, or stopped before it
+	changed   chan struct{} // closed and replaced when the stream that took the end closes or plays
+	pcm       []byte        // end of the song, nil if it stopped before it or played it itself
+	followers int           // streams opened after the song and not closed
+	taker     *Stream       // stream mixing the end with its start
+}
+
+func newSongEnd() *songEnd {
+	return &songEnd{done: make(chan struct{}), changed: make(chan struct{})}
+}
+
+// follow registers a stream opened after the song
+func (e *songEnd) follow() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.followers++
+}
+
+// unfollow is called when a stream opened after the song is closed.
+// If it took the end of the song without playing it, the end can be taken by another stream
+func (e *songEnd) unfollow(s *Stream) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.followers--
+	if e.taker == s && !s.played.Load() {
+		e.taker = nil
+		e.notify()
+	}
+}
+
+// playing is called when a stream opened after the song starts playing
+func (e *songEnd) playing(s *Stream) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.taker == s {
+		e.notify()
+	}
+}
+
+// notify wakes up the streams waiting for the end. It must be called with the lock
+func (e *songEnd) notify() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// finish is called when the song reached its end. It returns true if the end is left to a stream opened after the song,
+// false if the song has to play it
+func (e *songEnd) finish(pcm []byte) (left bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.ended {
+		return false
+	}
+	e.ended = true
+	if e.followers > 0 {
+		e.pcm = pcm
+	}
+	close(e.done)
+	return e.pcm != nil
+}
+
+// stop is called when the song stops before its end, so the streams after it don't wait for it
+func (e *songEnd) stop() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if !e.ended {
+		e.ended = true
+		close(e.done)
+	}
+}
+
+// take waits for the song to end, and returns its end. If another stream took it,
+// it waits for that stream to either give it back or start playing.
+// It returns nil if the end is not available, or if the context ends first
+func (e *songEnd) take(ctx context.Context, s *Stream) (pcm []byte) {
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return nil
+	}
+
+	for {
+		e.lock.Lock()
+		if e.pcm == nil || (e.taker != nil && e.taker.played.Load()) {
+			e.lock.Unlock()
+			return nil
+		}
+		if e.taker == nil {
+			e.taker = s
+			e.lock.Unlock()
+			return e.pcm
+		}
+		changed := e.changed
+		e.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// crossfade copies the pcm of the song to the encoder, as wav.
+// Its start is mixed with the end of the previous song, and its end is held back for the song after it
+func (s *Stream) crossfade(ctx context.Context, pcm io.Reader, w *io.PipeWriter, size int) {
+	_, err := w.Write(wavHeader())
+	if err == nil {
+		err = s.mixStart(ctx, pcm, w)
+	}
+	if err == nil {
+		err = s.holdEnd(pcm, w, size)
+	}
+	if err != nil {
+		s.end.stop()
+	}
+	w.CloseWithError(err)
+}
+
+// mixStart mixes the end of the previous song with the start of this one, fading from one to the other
+func (s *Stream) mixStart(ctx context.Context, pcm io.Reader, w io.Writer) error {
+	if s.previous == nil {
+		return nil
+	}
+	end := s.previous.take(ctx, s)
+	if end == nil {
+		return nil
+	}
+
+	start := make([]byte, len(end))
+	n, err := io.ReadFull(pcm, start)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	clear(start[n:]) // a song shorter than the crossfade
+
+	_, err = w.Write(mixPCM(end, start))
+	return err
+}
+
+// holdEnd copies the pcm keeping back the last `size` bytes, which are left to the next song or played fading out
+func (s *Stream) holdEnd(pcm io.Reader, w io.Writer, size int) error {
+	line := &delayLine{buf: make([]byte, size)}
+	if _, err := io.Copy(&delayWriter{line, w}, pcm); err != nil {
+		return err
+	}
+
+	end := line.bytes()
+	if s.end.finish(end) {
+		s.left.Store(int64(pcmDuration(len(end))))
+		return nil
+	}
+	_, err := w.Write(mixPCM(end, make([]byte, len(end))))
+	return err
+}
+
+// mixPCM returns the mix of `from` and `to`, of the same size, going linearly from the first to the second
+func mixPCM(from []byte, to []byte) (mixed []byte) {
+	mixed = make([]byte, len(from))
+	frames := len(from) / pcmFrameBytes
+
+	for frame := 0; frame < frames; frame++ {
+		gain := float64(frame) / float64(frames)
+		for sample := 0; sample < pcmChannels; sample++ {
+			at := frame*pcmFrameBytes + sample*pcmSampleBytes
+			a := float64(int16(binary.LittleEndian.Uint16(from[at:])))
+			b := float64(int16(binary.LittleEndian.Uint16(to[at:])))
+			value := max(math.MinInt16, min(math.MaxInt16, math.Round(a*(1-gain)+b*gain)))
+			binary.LittleEndian.PutUint16(mixed[at:], uint16(int16(value)))
+		}
+	}
+	return mixed
+}
+
+// delayLine keeps the last bytes written, up to the size of its buffer
+type delayLine struct {
+	buf   []byte
+	start int // oldest byte
+	n     int
+}
+
+// write adds the bytes to the line, writing to `w` the oldest ones that don't fit anymore
+func (d *delayLine) write(w io.Writer, p []byte) error {
+	for len(p) > 0 {
+		if d.n == len(d.buf) {
+			if len(d.buf) == 0 {
+				_, err := w.Write(p)
+				return err
+			}
+			// full: the oldest bytes leave the line and their place is taken by the new ones
+			chunk := min(len(p), len(d.buf)-d.start)
+			if _, err := w.Write(d.buf[d.start : d.start+chunk]); err != nil {
+				return err
+			}
+			copy(d.buf[d.start:], p[:chunk])
+			d.start = (d.start + chunk) % len(d.buf)
+			p = p[chunk:]
+			continue
+		}
+
+		end := (d.start + d.n) % len(d.buf)
+		chunk := min(len(p), len(d.buf)-d.n, len(d.buf)-end)
+		copy(d.buf[end:], p[:chunk])
+		d.n += chunk
+		p = p[chunk:]
+	}
+	return nil
+}
+
+// bytes returns the bytes in the line, from the oldest
+func (d *delayLine) bytes() []byte {
+	out := make([]byte, 0, d.n)
+	first := min(d.n, len(d.buf)-d.start)
+	out = append(out, d.buf[d.start:d.start+first]...)
+	return append(out, d.buf[:d.n-first]...)
+}
+
+type delayWriter struct {
+	line *delayLine
+	w    io.Writer
+}
+
+func (d *delayWriter) Write(p []byte) (int, error) {
+	if err := d.line.write(d.w, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,259 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+)
+
+const (
+	testCrossfade = 10 * time.Millisecond
+	testSong      = 50 * time.Millisecond
+	msBytes       = pcmRate / 1000 * pcmFrameBytes // 1ms of pcm
+)
+
+// rawDecoder reads sources that are already pcm
+type rawDecoder struct{}
+
+func (rawDecoder) Decode(ctx context.Context, r io.Reader, options *dca.EncodeOptions) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+// pcmEncoder checks the wav header and splits the pcm in frames of 1ms, to read the samples mixed
+type pcmEncoder struct {
+	t *testing.T
+}
+
+func (p pcmEncoder) Encode(r io.Reader, options *dca.EncodeOptions) (Frames, error) {
+	header := make([]byte, wavHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header, wavHeader()) {
+		p.t.Errorf("wrong wav header %q", header)
+	}
+	return ChunkEncoder{FrameSize: msBytes, FrameDuration: time.Millisecond}.Encode(r, options)
+}
+
+// crossfadePipeline plays songs of 50ms whose samples are all the number in the url
+func crossfadePipeline(t *testing.T) *Pipeline {
+	return &Pipeline{
+		Source: ReaderSource(func(ctx context.Context, url string) (io.ReadCloser, error) {
+			var value int16
+			switch url {
+			case "first":
+				value = 1000
+			case "second":
+				value = 2000
+			case "third":
+				value = 3000
+			}
+			song := make([]byte, 0, PCMBytes(testSong))
+			for len(song) < cap(song) {
+				song = binary.LittleEndian.AppendUint16(song, uint16(value))
+			}
+			return io.NopCloser(bytes.NewReader(song)), nil
+		}),
+		Decoder:     rawDecoder{},
+		Encoder:     pcmEncoder{t},
+		Crossfade:   testCrossfade,
+		BufferBytes: 1024,
+	}
+}
+
+// readSamples returns the first sample of every millisecond of the stream
+func readSamples(t *testing.T, stream *Stream) (samples []int16) {
+	t.Helper()
+
+	for {
+		frame, err := stream.OpusFrame()
+		if err == io.EOF {
+			return samples
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples = append(samples, int16(binary.LittleEndian.Uint16(frame)))
+	}
+}
+
+// checkSamples checks the samples of each part of the stream: constant, or going from a value to another
+func checkSamples(t *testing.T, name string, samples []int16, parts ...[3]int) {
+	t.Helper()
+
+	at := 0
+	for _, part := range parts {
+		length, from, to := part[0], part[1], part[2]
+		for j := 0; j < length; j++ {
+			if at >= len(samples) {
+				t.Fatalf("%s: %d ms, want more: %v", name, len(samples), samples)
+			}
+			want := from + (to-from)*j/length
+			if diff := int(samples[at]) - want; diff < -1 || diff > 1 {
+				t.Fatalf("%s: sample %d at %d ms, want %d: %v", name, samples[at], at, want, samples)
+			}
+			at++
+		}
+	}
+	if at != len(samples) {
+		t.Fatalf("%s: %d ms, want %d: %v", name, len(samples), at, samples)
+	}
+}
+
+// waitTaken waits until a stream took the end of the song
+func waitTaken(t *testing.T, stream *Stream, taker *Stream) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		stream.end.lock.Lock()
+		taken := stream.end.taker == taker
+		stream.end.lock.Unlock()
+		if taken {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("the end of the song not taken after 5s")
+}
+
+func TestCrossfade(t *testing.T) {
+	pipeline := crossfadePipeline(t)
+
+	first, err := pipeline.Open("first", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := pipeline.OpenAfter(first, "second", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	// the end of the first song is played by the second one
+	checkSamples(t, "first", readSamples(t, first), [3]int{40, 1000, 1000})
+	if left := first.Left(); left != testCrossfade {
+		t.Fatalf("%s left to the next song, want %s", left, testCrossfade)
+	}
+
+	// without a song after it, the second one fades out
+	checkSamples(t, "second", readSamples(t, second), [3]int{10, 1000, 2000}, [3]int{30, 2000, 2000}, [3]int{10, 2000, 0})
+	if left := second.Left(); left != 0 {
+		t.Fatalf("%s left without a song after", left)
+	}
+}
+
+// TestCrossfadeQueueChange replaces the next song after it took the end of the song playing:
+// the end goes to the song that replaces it
+func TestCrossfadeQueueChange(t *testing.T) {
+	pipeline := crossfadePipeline(t)
+
+	first, err := pipeline.Open("first", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := pipeline.OpenAfter(first, "second", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkSamples(t, "first", readSamples(t, first), [3]int{40, 1000, 1000})
+	waitTaken(t, first, second)
+
+	// the third song waits for the second one, which is removed from the queue before playing
+	third, err := pipeline.OpenAfter(first, "third", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	time.Sleep(10 * time.Millisecond)
+	second.Close()
+
+	checkSamples(t, "third", readSamples(t, third), [3]int{10, 1000, 3000}, [3]int{30, 3000, 3000}, [3]int{10, 3000, 0})
+}
+
+// TestCrossfadeTakenOnce opens two songs after the same one: the end is mixed only with the one that plays
+func TestCrossfadeTakenOnce(t *testing.T) {
+	pipeline := crossfadePipeline(t)
+
+	first, err := pipeline.Open("first", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := pipeline.OpenAfter(first, "second", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	readSamples(t, first)
+	waitTaken(t, first, second)
+	checkSamples(t, "second", readSamples(t, second), [3]int{10, 1000, 2000}, [3]int{30, 2000, 2000}, [3]int{10, 2000, 0})
+
+	third, err := pipeline.OpenAfter(first, "third", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	checkSamples(t, "third", readSamples(t, third), [3]int{40, 3000, 3000}, [3]int{10, 3000, 0})
+}
+
+// TestCrossfadeSkip closes the song before its end: the next one starts without waiting for it
+func TestCrossfadeSkip(t *testing.T) {
+	pipeline := crossfadePipeline(t)
+
+	first, err := pipeline.Open("first", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := pipeline.OpenAfter(first, "second", dca.StdEncodeOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if _, err = first.OpusFrame(); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	checkSamples(t, "second", readSamples(t, second), [3]int{40, 2000, 2000}, [3]int{10, 2000, 0})
+	if left := first.Left(); left != 0 {
+		t.Fatalf("%s left by the song skipped", left)
+	}
+}
+
+func TestDelayLine(t *testing.T) {
+	for _, size := range []int{0, 1, 7, 64} {
+		var out bytes.Buffer
+		line := &delayLine{buf: make([]byte, size)}
+
+		input := make([]byte, 100)
+		for j := range input {
+			input[j] = byte(j)
+		}
+		// writes of every size, to wrap around the buffer
+		for rest, chunk := input, 1; len(rest) > 0; chunk++ {
+			n := min(chunk, len(rest))
+			if err := line.write(&out, rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+
+		if !bytes.Equal(out.Bytes(), input[:len(input)-size]) {
+			t.Errorf("size %d: written %v", size, out.Bytes())
+		}
+		if !bytes.Equal(line.bytes(), input[len(input)-size:]) {
+			t.Errorf("size %d: held back %v", size, line.bytes())
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package audio
+
+import (
+	"io"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+)
+
+// Frames are the opus frames of a song, read one at a time
+type Frames interface {
+	dca.OpusReader
+	// Stop stops the encoding, throwing away the frames not read
+	Stop()
+}
+
+// Encoder turns the audio of a source into opus frames
+type Encoder interface {
+	Encode(r io.Reader, options *dca.EncodeOptions) (Frames, error)
+}
+
+// FfmpegEncoder encodes with ffmpeg, through dca
+type FfmpegEncoder struct{}
+
+func (FfmpegEncoder) Encode(r io.Reader, options *dca.EncodeOptions) (Frames, error) {
+	session, err := dca.EncodeMem(r, options)
+	if err != nil {
+		return nil, err
+	}
+	return &ffmpegFrames{session}, nil
+}
+
+type ffmpegFrames struct {
+	*dca.EncodeSession
+}
+
+func (f *ffmpegFrames) Stop() {
+	f.Cleanup()
+}
+
+// ChunkEncoder doesn't encode: it splits the input in frames of `FrameSize` bytes.
+// It's useful to play sources that don't need ffmpeg
+type ChunkEncoder struct {
+	FrameSize     int
+	FrameDuration time.Duration
+}
+
+func (c ChunkEncoder) Encode(r io.Reader, options *dca.EncodeOptions) (Frames, error) {
+	return &chunkFrames{r: r, size: c.FrameSize, duration: c.FrameDuration}, nil
+}
+
+type chunkFrames struct {
+	r        io.Reader
+	size     int
+	duration time.Duration
+}
+
+func (c *chunkFrames) OpusFrame() (frame []byte, err error) {
+	frame = make([]byte, c.size)
+	n, err := io.ReadFull(c.r, frame)
+	if err == io.ErrUnexpectedEOF && n > 0 {
+		return frame[:n], nil
+	}
+	return frame, err
+}
+
+func (c *chunkFrames) FrameDuration() time.Duration {
+	return c.duration
+}
+
+func (c *chunkFrames) Stop() {
+	if closer, ok := c.r.(io.Closer); ok {
+		closer.Close()
+	}
+}
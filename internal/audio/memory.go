@@ -0,0 +1,76 @@
+package audio
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrMemoryLimit = errors.New("memory limit reached")
+
+// MemoryBudget limits the memory used by the streams.
+// Budgets can be nested, like the one of a server inside the global one: a reservation must fit in both
+type MemoryBudget struct {
+	lock   sync.Mutex
+	parent *MemoryBudget
+	limit  int64 // 0 for no limit
+	used   int64
+	peak   int64
+}
+
+func NewMemoryBudget(limit int64, parent *MemoryBudget) *MemoryBudget {
+	return &MemoryBudget{limit: limit, parent: parent}
+}
+
+// Reserve reserves `size` bytes, returns false if they don't fit in the budget or in its parents
+func (b *MemoryBudget) Reserve(size int64) (res bool) {
+	b.lock.Lock()
+	if b.limit > 0 && b.used+size > b.limit {
+		b.lock.Unlock()
+		return false
+	}
+	b.used += size
+	b.peak = max(b.peak, b.used)
+	b.lock.Unlock()
+
+	if b.parent != nil && !b.parent.Reserve(size) {
+		// only this budget has the reservation, the parent refused it
+		b.lock.Lock()
+		b.used -= size
+		b.lock.Unlock()
+		return false
+	}
+	return true
+}
+
+// Release gives back bytes reserved
+func (b *MemoryBudget) Release(size int64) {
+	b.lock.Lock()
+	b.used -= size
+	b.lock.Unlock()
+
+	if b.parent != nil {
+		b.parent.Release(size)
+	}
+}
+
+// SetLimit changes the limit. The reservations already made are kept even if they don't fit anymore
+func (b *MemoryBudget) SetLimit(limit int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.limit = limit
+}
+
+// MemoryStats are the numbers of a budget
+type MemoryStats struct {
+	Used  int64
+	Peak  int64
+	Limit int64
+}
+
+func (b *MemoryBudget) Stats() MemoryStats {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return MemoryStats{Used: b.used, Peak: b.peak, Limit: b.limit}
+}
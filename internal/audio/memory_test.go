@@ -0,0 +1,39 @@
+package audio
+
+import "testing"
+
+func TestMemoryBudget(t *testing.T) {
+	global := NewMemoryBudget(100, nil)
+	first := NewMemoryBudget(80, global)
+	second := NewMemoryBudget(80, global)
+
+	if !first.Reserve(60) {
+		t.Fatal("60 bytes don't fit in the first server")
+	}
+	if first.Reserve(30) {
+		t.Fatal("90 bytes fit in the first server, limited to 80")
+	}
+
+	// fits in the server but not in the global budget
+	if second.Reserve(50) {
+		t.Fatal("110 bytes fit in the global budget, limited to 100")
+	}
+	if used := second.Stats().Used; used != 0 {
+		t.Fatalf("%d bytes used by the second server after the refused reservation", used)
+	}
+	if used := global.Stats().Used; used != 60 {
+		t.Fatalf("%d bytes used in the global budget, want the 60 of the first server", used)
+	}
+
+	if !second.Reserve(40) {
+		t.Fatal("40 bytes don't fit in the global budget with 40 free")
+	}
+
+	first.Release(60)
+	second.Release(40)
+	for name, budget := range map[string]*MemoryBudget{"global": global, "first": first, "second": second} {
+		if used := budget.Stats().Used; used != 0 {
+			t.Fatalf("%d bytes still used in the %s budget", used, name)
+		}
+	}
+}
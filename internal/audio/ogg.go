@@ -0,0 +1,108 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// oggDemuxer reads the opus packets of an ogg file, without decoding them.
+// Only the first logical stream is read
+type oggDemuxer struct {
+	r       *bufio.Reader
+	serial  uint32
+	started bool
+	head    []byte   // OpusHead packet of the stream
+	packets [][]byte // complete packets of the last page, not returned yet
+	partial []byte   // packet that continues in the next page
+}
+
+func newOggDemuxer(r *bufio.Reader) *oggDemuxer {
+	return &oggDemuxer{r: r}
+}
+
+// Packet returns the next opus packet of the file, skipping the headers
+func (d *oggDemuxer) Packet() (packet []byte, err error) {
+	for {
+		for len(d.packets) > 0 {
+			packet = d.packets[0]
+			d.packets = d.packets[1:]
+
+			if bytes.HasPrefix(packet, []byte("OpusHead")) {
+				d.head = packet
+				continue
+			}
+			if bytes.HasPrefix(packet, []byte("OpusTags")) {
+				continue
+			}
+			if d.head == nil {
+				return nil, errors.New("ogg stream is not opus")
+			}
+			return packet, nil
+		}
+
+		if err = d.readPage(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Head returns the OpusHead packet, known after the first packet
+func (d *oggDemuxer) Head() []byte {
+	return d.head
+}
+
+// readPage reads the next page of the stream and splits it in packets
+func (d *oggDemuxer) readPage() (err error) {
+	header := make([]byte, 27)
+	if _, err = io.ReadFull(d.r, header); err != nil {
+		return err
+	}
+	if string(header[:4]) != "OggS" {
+		return errors.New("invalid ogg page")
+	}
+
+	continued := header[5]&0x01 != 0
+	serial := binary.LittleEndian.Uint32(header[14:18])
+
+	lacing := make([]byte, header[26])
+	if _, err = io.ReadFull(d.r, lacing); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	size := 0
+	for _, l := range lacing {
+		size += int(l)
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(d.r, data); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	if !d.started {
+		d.serial = serial
+		d.started = true
+	}
+	if serial != d.serial { // another logical stream
+		return nil
+	}
+	if !continued {
+		d.partial = nil
+	}
+
+	packet := d.partial
+	offset := 0
+	for _, l := range lacing {
+		packet = append(packet, data[offset:offset+int(l)]...)
+		offset += int(l)
+		if l < 255 {
+			d.packets = append(d.packets, packet)
+			packet = nil
+		}
+	}
+	d.partial = packet
+
+	return nil
+}
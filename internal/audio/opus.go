@@ -0,0 +1,46 @@
+package audio
+
+import (
+	"errors"
+	"time"
+)
+
+var errEmptyPacket = errors.New("empty opus packet")
+
+// opusFrameDurations are the durations of the frames of each opus configuration, from the first byte of a packet (the TOC).
+// See RFC 6716, section 3.1
+var opusFrameDurations = [32]time.Duration{
+	// SILK
+	10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond,
+	// Hybrid
+	10 * time.Millisecond, 20 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond,
+	// CELT
+	2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+	2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+	2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+	2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+}
+
+// opusPacketDuration returns the duration of the audio in the opus packet
+func opusPacketDuration(packet []byte) (duration time.Duration, err error) {
+	if len(packet) == 0 {
+		return 0, errEmptyPacket
+	}
+
+	toc := packet[0]
+	frames := 1
+	switch toc & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0, errors.New("opus packet without frame count")
+		}
+		frames = int(packet[1] & 0x3F)
+	}
+
+	return time.Duration(frames) * opusFrameDurations[toc>>3], nil
+}
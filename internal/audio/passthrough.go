@@ -0,0 +1,199 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+)
+
+// passthroughFrameDuration is the only frame duration discord plays correctly
+const passthroughFrameDuration = 20 * time.Millisecond
+
+var errNotPassthrough = errors.New("the source can't be passed through")
+
+// PassthroughEncoder sends the opus packets of webm and ogg sources as they are, without decoding and encoding them again.
+// It's used only when the options don't change the audio (no filters, volume, start time),
+// and the source is opus at 48 kHz with 20 ms frames. Otherwise the audio goes to the fallback encoder
+type PassthroughEncoder struct {
+	Fallback Encoder
+}
+
+// CanPassthrough returns true if the options leave the audio as it is
+func CanPassthrough(options *dca.EncodeOptions) bool {
+	return options.RawOutput &&
+		options.AudioFilter == "" &&
+		options.Volume == 1 &&
+		options.StartTime == 0 &&
+		options.FrameRate == 48000 &&
+		time.Duration(options.FrameDuration)*time.Millisecond == passthroughFrameDuration
+}
+
+func (p PassthroughEncoder) Encode(r io.Reader, options *dca.EncodeOptions) (Frames, error) {
+	if !CanPassthrough(options) {
+		return p.Fallback.Encode(r, options)
+	}
+
+	// the source is inspected at the first frame, so that opening the stream never waits for the download
+	return &passthroughFrames{r: r, options: options, fallback: p.Fallback}, nil
+}
+
+// opusDemuxer reads the opus packets of a container
+type opusDemuxer interface {
+	Packet() ([]byte, error)
+}
+
+type passthroughFrames struct {
+	r        io.Reader
+	options  *dca.EncodeOptions
+	fallback Encoder
+
+	decided bool
+	demuxer opusDemuxer
+	first   []byte // first packet, read to decide
+
+	lock    sync.Mutex
+	encoded Frames // frames of the fallback encoder, if used
+	stopped bool
+}
+
+func (p *passthroughFrames) OpusFrame() (frame []byte, err error) {
+	if !p.decided {
+		p.decided = true
+		if err = p.decide(); err != nil {
+			return nil, err
+		}
+	}
+
+	p.lock.Lock()
+	encoded := p.encoded
+	p.lock.Unlock()
+	if encoded != nil {
+		return encoded.OpusFrame()
+	}
+
+	if p.first != nil {
+		frame, p.first = p.first, nil
+		return frame, nil
+	}
+	return p.demuxer.Packet()
+}
+
+// decide reads the start of the source, and falls back to encoding it if it can't be passed through.
+// The bytes read are given again to the fallback encoder
+func (p *passthroughFrames) decide() (err error) {
+	recorder := &recordingReader{r: p.r, recording: true}
+	reader := bufio.NewReaderSize(recorder, 64*1024)
+
+	p.demuxer, p.first, err = openOpusDemuxer(reader)
+	if err == nil {
+		recorder.stop()
+		return nil
+	}
+
+	frames, err := p.fallback.Encode(io.MultiReader(bytes.NewReader(recorder.stop()), p.r), p.options)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.encoded = frames
+	if p.stopped {
+		frames.Stop()
+	}
+	return nil
+}
+
+// openOpusDemuxer recognizes the container and reads the first packet.
+// It returns errNotPassthrough if the source is not opus that can be sent as it is
+func openOpusDemuxer(r *bufio.Reader) (demuxer opusDemuxer, first []byte, err error) {
+	magic, err := r.Peek(4)
+	if err != nil {
+		return nil, nil, errNotPassthrough
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		webm := newWebmDemuxer(r)
+		if first, err = webm.Packet(); err != nil {
+			return nil, nil, errNotPassthrough
+		}
+		track := webm.Track()
+		if track.frequency != 48000 || track.channels > 2 {
+			return nil, nil, errNotPassthrough
+		}
+		demuxer = webm
+	case string(magic) == "OggS":
+		ogg := newOggDemuxer(r)
+		if first, err = ogg.Packet(); err != nil {
+			return nil, nil, errNotPassthrough
+		}
+		// OpusHead: channels at 9, sample rate of the original audio at 12, mapping family at 18
+		head := ogg.Head()
+		if len(head) < 19 || head[9] > 2 || head[18] != 0 || binary.LittleEndian.Uint32(head[12:16]) != 48000 {
+			return nil, nil, errNotPassthrough
+		}
+		demuxer = ogg
+	default:
+		return nil, nil, errNotPassthrough
+	}
+
+	duration, err := opusPacketDuration(first)
+	if err != nil || duration != passthroughFrameDuration {
+		return nil, nil, errNotPassthrough
+	}
+	return demuxer, first, nil
+}
+
+func (p *passthroughFrames) FrameDuration() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.encoded != nil {
+		return p.encoded.FrameDuration()
+	}
+	return passthroughFrameDuration
+}
+
+func (p *passthroughFrames) Stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.stopped = true
+	if p.encoded != nil {
+		p.encoded.Stop()
+	}
+	if closer, ok := p.r.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// recordingReader keeps a copy of the bytes read, until stopped
+type recordingReader struct {
+	r         io.Reader
+	recording bool
+	recorded  []byte
+}
+
+func (r *recordingReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	if r.recording {
+		r.recorded = append(r.recorded, p[:n]...)
+	}
+	return n, err
+}
+
+// stop stops recording and returns the bytes recorded
+func (r *recordingReader) stop() (recorded []byte) {
+	recorded = r.recorded
+	r.recording = false
+	r.recorded = nil
+	return recorded
+}
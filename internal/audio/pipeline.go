@@ -0,0 +1,265 @@
+package audio
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+)
+
+// Pipeline plays a song in stages: the source produces the audio, which is buffered and then turned into opus frames by the encoder.
+// The frames are then sent to a sink by a Sender.
+// With a crossfade, the audio is decoded to pcm before the encoder, to mix the end of each song with the start of the next one
+type Pipeline struct {
+	Source      Source
+	Encoder     Encoder
+	Decoder     Decoder       // decodes the songs to mix them, needed by the crossfade
+	Crossfade   time.Duration // how long the songs overlap, 0 to play them one after the other
+	BufferBytes int           // size of the buffer between the source and the encoder
+	Budget      *MemoryBudget // memory available to the streams, nil for no limit
+}
+
+// Stream is a song going through the pipeline
+type Stream struct {
+	URL string
+
+	cancel  context.CancelFunc
+	buffer  *Buffer
+	frames  Frames
+	release func() // gives back the memory reserved
+	closing sync.Once
+
+	pcm      *io.PipeReader // pcm mixed, read by the encoder. nil without crossfade
+	end      *songEnd       // end of the song, for the stream after it. nil without crossfade
+	previous *songEnd       // end of the song before, mixed with the start of this one
+	left     atomic.Int64   // duration of the end left to the next song
+	played   atomic.Bool    // true once the first frame was read
+}
+
+// Open starts the source and the encoder of the song.
+// The frames are buffered until they are read.
+// The memory of the buffers is reserved from the budget, if it doesn't fit it returns ErrMemoryLimit
+func (p *Pipeline) Open(url string, options *dca.EncodeOptions) (stream *Stream, err error) {
+	return p.OpenAfter(nil, url, options)
+}
+
+// OpenAfter opens the song that plays after `previous`, like Open.
+// With a crossfade, the end of the previous song is mixed with the start of this one, if the previous song reaches its end
+func (p *Pipeline) OpenAfter(previous *Stream, url string, options *dca.EncodeOptions) (stream *Stream, err error) {
+	crossfade := p.Crossfade > 0 && p.Decoder != nil
+	endBytes := 0
+	if crossfade {
+		endBytes = PCMBytes(p.Crossfade)
+	}
+
+	reserved := int64(p.BufferBytes) + framesMemory(options) + int64(endBytes)
+	if p.Budget != nil && !p.Budget.Reserve(reserved) {
+		log.Println("ERR: internal/audio/pipeline.go: Error opening the stream - ", ErrMemoryLimit)
+		return nil, ErrMemoryLimit
+	}
+	release := func() {
+		if p.Budget != nil {
+			p.Budget.Release(reserved)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	source, err := p.Source.Open(ctx, url)
+	if err != nil {
+		cancel()
+		release()
+		log.Println("ERR: internal/audio/pipeline.go: Error starting the source - ", err)
+		return nil, err
+	}
+
+	buffer := NewBuffer(p.BufferBytes)
+
+	sources.Add(1)
+	go func() {
+		defer sources.Done()
+		_, err := io.Copy(buffer, source)
+		buffer.CloseWithError(err)
+		source.Close()
+	}()
+
+	stream = &Stream{
+		URL:     url,
+		cancel:  cancel,
+		buffer:  buffer,
+		release: release,
+	}
+
+	var input io.Reader = buffer
+	if crossfade {
+		pcm, err := p.Decoder.Decode(ctx, buffer, options)
+		if err != nil {
+			cancel()
+			buffer.Close()
+			release()
+			log.Println("ERR: internal/audio/pipeline.go: Error decoding - ", err)
+			return nil, err
+		}
+
+		stream.end = newSongEnd()
+		if previous != nil && previous.end != nil {
+			stream.previous = previous.end
+			stream.previous.follow()
+		}
+
+		r, w := io.Pipe()
+		stream.pcm = r
+		input = r
+		options = pcmOptions(options)
+
+		sources.Add(1)
+		go func() {
+			defer sources.Done()
+			stream.crossfade(ctx, pcm, w, endBytes)
+			pcm.Close()
+		}()
+	}
+
+	stream.frames, err = p.Encoder.Encode(input, options)
+	if err != nil {
+		stream.closeStages()
+		release()
+		log.Println("ERR: internal/audio/pipeline.go: Error encoding - ", err)
+		return nil, err
+	}
+
+	streams.add(stream)
+	return stream, nil
+}
+
+// framesMemory estimates the memory used by the frames encoded ahead
+func framesMemory(options *dca.EncodeOptions) int64 {
+	const frameOverhead = 64 // frame struct and slice header
+
+	frameBytes := options.Bitrate * 1000 / 8 * options.FrameDuration / 1000
+	return int64(options.BufferedFrames) * int64(frameBytes+frameOverhead)
+}
+
+func (s *Stream) OpusFrame() (frame []byte, err error) {
+	if !s.played.Swap(true) && s.previous != nil {
+		s.previous.playing(s)
+	}
+	return s.frames.OpusFrame()
+}
+
+// Left returns how much of the end of the song was left to the song after it, to mix them
+func (s *Stream) Left() time.Duration {
+	return time.Duration(s.left.Load())
+}
+
+func (s *Stream) FrameDuration() time.Duration {
+	return s.frames.FrameDuration()
+}
+
+// Close stops the source and the encoder, throwing away the frames not read.
+// It can be called more than once
+func (s *Stream) Close() {
+	s.closing.Do(func() {
+		s.closeStages()
+		s.frames.Stop()
+		s.release()
+		streams.remove(s)
+	})
+}
+
+// closeStages stops the source and the decoder
+func (s *Stream) closeStages() {
+	s.cancel()
+	s.buffer.Close()
+	if s.pcm != nil {
+		s.pcm.Close()
+	}
+	if s.end != nil {
+		s.end.stop()
+	}
+	if s.previous != nil {
+		s.previous.unfollow(s)
+	}
+}
+
+// streams are the streams open, for the metrics
+var streams = openStreams{set: map[*Stream]bool{}}
+
+// sources are the sources still running, including the ones of the streams closed that didn't exit yet
+var sources sync.WaitGroup
+
+// CloseStreams closes every stream open and waits for their sources and encoders to exit, or for the context to end.
+// It's used at shutdown, so that no yt-dlp or ffmpeg is left running
+func CloseStreams(ctx context.Context) error {
+	streams.lock.Lock()
+	list := make([]*Stream, 0, len(streams.set))
+	for s := range streams.set {
+		list = append(list, s)
+	}
+	streams.lock.Unlock()
+
+	var closing sync.WaitGroup
+	for _, s := range list {
+		closing.Add(1)
+		go func() {
+			defer closing.Done()
+			s.Close()
+		}()
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		closing.Wait()
+		sources.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type openStreams struct {
+	lock sync.Mutex
+	set  map[*Stream]bool
+}
+
+func (o *openStreams) add(s *Stream) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.set[s] = true
+}
+
+func (o *openStreams) remove(s *Stream) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	delete(o.set, s)
+}
+
+// StreamStats are the numbers of the streams open
+type StreamStats struct {
+	Streams       int   // streams open, playing or prefetched
+	BufferedBytes int64 // bytes downloaded and not encoded yet
+	BufferBytes   int64 // size of the buffers
+}
+
+func GetStreamStats() (stats StreamStats) {
+	streams.lock.Lock()
+	defer streams.lock.Unlock()
+
+	for s := range streams.set {
+		stats.Streams++
+		stats.BufferedBytes += int64(s.buffer.Len())
+		stats.BufferBytes += int64(s.buffer.Cap())
+	}
+	return stats
+}
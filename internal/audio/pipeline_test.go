@@ -0,0 +1,261 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+)
+
+const (
+	wavHeaderSize = 44
+	pcmFrameSize  = 48000 / 50 * 2 * 2 // 20ms of 48kHz stereo 16 bits
+	frameDuration = 20 * time.Millisecond
+)
+
+func TestWavSource(t *testing.T) {
+	source, err := WavSource{Duration: time.Second}.Open(context.Background(), "any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	data, err := io.ReadAll(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data) != wavHeaderSize+48000*4 {
+		t.Fatalf("%d bytes, want %d", len(data), wavHeaderSize+48000*4)
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:16]) != "WAVEfmt " || string(data[36:40]) != "data" {
+		t.Fatalf("wrong header %q", data[:wavHeaderSize])
+	}
+	if size := binary.LittleEndian.Uint32(data[40:44]); int(size) != len(data)-wavHeaderSize {
+		t.Fatalf("data size %d in the header, want %d", size, len(data)-wavHeaderSize)
+	}
+	if !bytes.Equal(data[wavHeaderSize:], make([]byte, len(data)-wavHeaderSize)) {
+		t.Fatal("the data is not silence")
+	}
+}
+
+func TestChunkEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  int
+		frames []int // size of the frames read
+	}{
+		{"empty", 0, nil},
+		{"exact", 30, []int{10, 10, 10}},
+		{"last frame shorter", 25, []int{10, 10, 5}},
+		{"shorter than a frame", 3, []int{3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frames, err := ChunkEncoder{FrameSize: 10, FrameDuration: frameDuration}.Encode(bytes.NewReader(make([]byte, test.input)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frames.FrameDuration() != frameDuration {
+				t.Fatalf("frame duration %s", frames.FrameDuration())
+			}
+
+			var sizes []int
+			for {
+				frame, err := frames.OpusFrame()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				sizes = append(sizes, len(frame))
+			}
+
+			if len(sizes) != len(test.frames) {
+				t.Fatalf("frames %v, want %v", sizes, test.frames)
+			}
+			for j := range sizes {
+				if sizes[j] != test.frames[j] {
+					t.Fatalf("frames %v, want %v", sizes, test.frames)
+				}
+			}
+		})
+	}
+}
+
+func TestCountingSink(t *testing.T) {
+	sink := NewCountingSink(10 * time.Millisecond)
+	defer sink.Close()
+
+	for j := 0; j < 5; j++ {
+		sink.OpusSend() <- []byte{0}
+	}
+
+	if sink.Frames() != 5 {
+		t.Fatalf("%d frames, want 5", sink.Frames())
+	}
+	// the first frame is counted after the first tick, the other 4 one tick apart
+	if elapsed := sink.Elapsed(); elapsed < 35*time.Millisecond {
+		t.Fatalf("5 frames in %s, faster than the pace", elapsed)
+	}
+}
+
+// play sends the song through the pipeline to a sink reading at the pace of discord.
+// `during` runs on the stream and its sender while it plays
+func play(t *testing.T, pipeline *Pipeline, options *dca.EncodeOptions, during func(stream *Stream, sender *Sender)) (sink *CountingSink, err error) {
+	t.Helper()
+
+	stream, err := pipeline.Open("song", options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	sink = NewCountingSink(frameDuration)
+	t.Cleanup(sink.Close)
+
+	done := make(chan error, 1)
+	sender := NewSender(stream, sink, done)
+	if during != nil {
+		during(stream, sender)
+	}
+
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the song didn't end after 10s")
+	}
+	return sink, err
+}
+
+func chunkPipeline(duration time.Duration) *Pipeline {
+	return &Pipeline{
+		Source:      WavSource{Duration: duration},
+		Encoder:     ChunkEncoder{FrameSize: pcmFrameSize, FrameDuration: frameDuration},
+		BufferBytes: 64 << 10,
+	}
+}
+
+func TestPipelineTiming(t *testing.T) {
+	sink, err := play(t, chunkPipeline(500*time.Millisecond), dca.StdEncodeOptions, nil)
+	if err != io.EOF {
+		t.Fatalf("ended with %v, want EOF", err)
+	}
+
+	// 25 frames of audio, and one with the header of the wav
+	if sink.Frames() != 26 {
+		t.Fatalf("%d frames, want 26", sink.Frames())
+	}
+	if elapsed := sink.Elapsed(); elapsed < 450*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("played in %s, want about 500ms", elapsed)
+	}
+}
+
+func TestPipelinePause(t *testing.T) {
+	const pause = 300 * time.Millisecond
+
+	var position time.Duration
+	sink, err := play(t, chunkPipeline(500*time.Millisecond), dca.StdEncodeOptions, func(stream *Stream, sender *Sender) {
+		time.Sleep(100 * time.Millisecond)
+		sender.SetPaused(true)
+		time.Sleep(50 * time.Millisecond) // the frame being sent when it paused
+		position = sender.PlaybackPosition()
+
+		time.Sleep(pause)
+		if now := sender.PlaybackPosition(); now != position {
+			t.Errorf("position moved from %s to %s while paused", position, now)
+		}
+		sender.SetPaused(false)
+	})
+	if err != io.EOF {
+		t.Fatalf("ended with %v, want EOF", err)
+	}
+
+	if sink.Frames() != 26 {
+		t.Fatalf("%d frames, want 26", sink.Frames())
+	}
+	if elapsed := sink.Elapsed(); elapsed < 450*time.Millisecond+pause {
+		t.Fatalf("played in %s with a pause of %s", elapsed, pause)
+	}
+}
+
+func TestPipelineSkip(t *testing.T) {
+	sink, err := play(t, chunkPipeline(5*time.Second), dca.StdEncodeOptions, func(stream *Stream, sender *Sender) {
+		time.Sleep(100 * time.Millisecond)
+		stream.Close()
+	})
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("ended with %v, want %v", err, io.ErrClosedPipe)
+	}
+	// the frames already in the buffer are still sent
+	if frames := sink.Frames(); frames == 0 || frames > 40 {
+		t.Fatalf("%d frames sent before the skip, want about 5", frames)
+	}
+}
+
+// TestPipelineFfmpeg plays the wav encoded by ffmpeg, like the songs of the bot
+func TestPipelineFfmpeg(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+
+	pipeline := &Pipeline{
+		Source:      WavSource{Duration: time.Second},
+		Encoder:     FfmpegEncoder{},
+		BufferBytes: 64 << 10,
+	}
+
+	var position time.Duration
+	sink, err := play(t, pipeline, dca.StdEncodeOptions, func(stream *Stream, sender *Sender) {
+		time.Sleep(200 * time.Millisecond)
+		sender.SetPaused(true)
+		time.Sleep(200 * time.Millisecond)
+		position = sender.PlaybackPosition()
+		sender.SetPaused(false)
+	})
+	if err != io.EOF {
+		t.Fatalf("ended with %v, want EOF", err)
+	}
+
+	// the opus encoder can add a frame or two of padding
+	if frames := sink.Frames(); frames < 49 || frames > 53 {
+		t.Fatalf("%d frames, want 50", frames)
+	}
+	if position <= 0 || position >= time.Second {
+		t.Fatalf("paused at %s", position)
+	}
+	if elapsed := sink.Elapsed(); elapsed < 1100*time.Millisecond {
+		t.Fatalf("played in %s with a pause of 200ms", elapsed)
+	}
+}
+
+func TestPipelineFfmpegSkip(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+
+	pipeline := &Pipeline{
+		Source:      WavSource{Duration: 10 * time.Second},
+		Encoder:     FfmpegEncoder{},
+		BufferBytes: 64 << 10,
+	}
+
+	sink, err := play(t, pipeline, dca.StdEncodeOptions, func(stream *Stream, sender *Sender) {
+		time.Sleep(200 * time.Millisecond)
+		stream.Close()
+	})
+	if err == nil || err == io.EOF {
+		t.Fatalf("ended with %v after the stream was closed", err)
+	}
+	if frames := sink.Frames(); frames == 0 || frames > 40 {
+		t.Fatalf("%d frames sent before the skip, want about 10", frames)
+	}
+}
@@ -0,0 +1,129 @@
+package audio
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+)
+
+var ErrVoiceConnectionClosed = errors.New("voice connection closed")
+var ErrSenderStopped = errors.New("sender stopped")
+
+// Sender sends the frames of the source to a sink, at the pace the sink reads them.
+// It works like dca.StreamingSession, but with any sink
+type Sender struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	source     dca.OpusReader
+	send       chan<- []byte
+	done       chan error
+	stop       chan struct{}
+	paused     bool
+	stopped    bool
+	finished   bool
+	framesSent int
+}
+
+// NewSender starts sending the frames. When the source ends the result is sent on `done`
+func NewSender(source dca.OpusReader, sink Sink, done chan error) *Sender {
+	sender := &Sender{
+		source: source,
+		send:   sink.OpusSend(),
+		done:   done,
+		stop:   make(chan struct{}),
+	}
+	sender.cond = sync.NewCond(&sender.lock)
+
+	go sender.run()
+
+	return sender
+}
+
+func (s *Sender) run() {
+	var err error
+
+	for {
+		s.lock.Lock()
+		for s.paused && !s.stopped {
+			s.cond.Wait()
+		}
+		stopped := s.stopped
+		s.lock.Unlock()
+
+		if stopped {
+			err = ErrSenderStopped
+			break
+		}
+
+		var frame []byte
+		frame, err = s.source.OpusFrame()
+		if err != nil {
+			break
+		}
+
+		timeout := time.NewTimer(time.Second)
+		select {
+		case s.send <- frame:
+			timeout.Stop()
+		case <-timeout.C:
+			err = ErrVoiceConnectionClosed
+		case <-s.stop:
+			timeout.Stop()
+			err = ErrSenderStopped
+		}
+		if err != nil {
+			break
+		}
+
+		s.lock.Lock()
+		s.framesSent++
+		s.lock.Unlock()
+	}
+
+	s.lock.Lock()
+	s.finished = true
+	s.lock.Unlock()
+
+	s.done <- err
+}
+
+func (s *Sender) SetPaused(paused bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.finished {
+		return
+	}
+	s.paused = paused
+	s.cond.Broadcast()
+}
+
+// Stop stops sending without closing the source, so another sender can continue it from the next frame
+func (s *Sender) Stop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.stopped {
+		s.stopped = true
+		close(s.stop)
+	}
+	s.cond.Broadcast()
+}
+
+func (s *Sender) Paused() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.paused
+}
+
+// PlaybackPosition returns the duration of the audio sent so far
+func (s *Sender) PlaybackPosition() time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return time.Duration(s.framesSent) * s.source.FrameDuration()
+}
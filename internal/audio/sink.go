@@ -0,0 +1,84 @@
+package audio
+
+import (
+	"sync"
+	"time"
+)
+
+// Sink consumes the opus frames, usually a voice connection
+type Sink interface {
+	OpusSend() chan<- []byte
+}
+
+// CountingSink counts the frames it receives instead of playing them.
+// If `Pace` is set, it reads one frame every `Pace` like a voice connection does
+type CountingSink struct {
+	lock sync.Mutex
+
+	pace   time.Duration
+	frames int
+	first  time.Time
+	last   time.Time
+
+	send chan []byte
+}
+
+func NewCountingSink(pace time.Duration) *CountingSink {
+	sink := &CountingSink{
+		pace: pace,
+		send: make(chan []byte),
+	}
+	go sink.receive()
+	return sink
+}
+
+func (c *CountingSink) receive() {
+	var ticker <-chan time.Time
+	if c.pace > 0 {
+		t := time.NewTicker(c.pace)
+		defer t.Stop()
+		ticker = t.C
+	}
+
+	for {
+		if ticker != nil {
+			<-ticker
+		}
+		if _, ok := <-c.send; !ok {
+			return
+		}
+
+		c.lock.Lock()
+		c.last = time.Now()
+		if c.frames == 0 {
+			c.first = c.last
+		}
+		c.frames++
+		c.lock.Unlock()
+	}
+}
+
+func (c *CountingSink) OpusSend() chan<- []byte {
+	return c.send
+}
+
+// Frames returns the number of frames received
+func (c *CountingSink) Frames() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.frames
+}
+
+// Elapsed returns the time between the first and the last frame received
+func (c *CountingSink) Elapsed() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.last.Sub(c.first)
+}
+
+// Close stops the sink and its ticker
+func (c *CountingSink) Close() {
+	close(c.send)
+}
@@ -0,0 +1,104 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Source produces the audio of a song, in any format ffmpeg can read.
+// The audio stops when the context is cancelled
+type Source interface {
+	Open(ctx context.Context, url string) (io.ReadCloser, error)
+}
+
+// YtdlpSource downloads the audio with yt-dlp
+type YtdlpSource struct {
+	Format string // format selection passed to yt-dlp
+}
+
+func (y YtdlpSource) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	source := CommandSource{
+		Name: "yt-dlp",
+		Args: []string{"-f", y.Format, "-o", "-", "--download-sections", "*from-url", url},
+	}
+	return source.Open(ctx, url)
+}
+
+// CommandSource runs a command and reads the audio from its output
+type CommandSource struct {
+	Name string
+	Args []string
+}
+
+func (c CommandSource) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &commandOutput{stdout, cmd}, nil
+}
+
+// commandOutput is the output of a command, closing it waits for the command to exit
+type commandOutput struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (c *commandOutput) Close() error {
+	c.ReadCloser.Close()
+	return c.cmd.Wait()
+}
+
+// ReaderSource returns the audio from a function, to play audio that's not from a process
+type ReaderSource func(ctx context.Context, url string) (io.ReadCloser, error)
+
+func (r ReaderSource) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	return r(ctx, url)
+}
+
+// WavSource generates silence in wav format, 48kHz stereo, for every url
+type WavSource struct {
+	Duration time.Duration
+}
+
+func (w WavSource) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	const rate, channels, bytesPerSample = 48000, 2, 2
+
+	size := int(w.Duration.Seconds()*rate) * channels * bytesPerSample
+
+	var header bytes.Buffer
+	header.WriteString("RIFF")
+	binary.Write(&header, binary.LittleEndian, uint32(36+size))
+	header.WriteString("WAVEfmt ")
+	binary.Write(&header, binary.LittleEndian, uint32(16))
+	binary.Write(&header, binary.LittleEndian, uint16(1)) // pcm
+	binary.Write(&header, binary.LittleEndian, uint16(channels))
+	binary.Write(&header, binary.LittleEndian, uint32(rate))
+	binary.Write(&header, binary.LittleEndian, uint32(rate*channels*bytesPerSample))
+	binary.Write(&header, binary.LittleEndian, uint16(channels*bytesPerSample))
+	binary.Write(&header, binary.LittleEndian, uint16(bytesPerSample*8))
+	header.WriteString("data")
+	binary.Write(&header, binary.LittleEndian, uint32(size))
+
+	data := io.MultiReader(&header, io.LimitReader(zeroReader{}, int64(size)))
+	return io.NopCloser(data), nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
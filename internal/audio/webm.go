@@ -0,0 +1,274 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// ids of the matroska elements read by the demuxer
+const (
+	ebmlSegment           = 0x18538067
+	ebmlTracks            = 0x1654AE6B
+	ebmlTrackEntry        = 0xAE
+	ebmlTrackNumber       = 0xD7
+	ebmlCodecID           = 0x86
+	ebmlAudio             = 0xE1
+	ebmlSamplingFrequency = 0xB5
+	ebmlChannels          = 0x9F
+	ebmlCluster           = 0x1F43B675
+	ebmlBlockGroup        = 0xA0
+	ebmlBlock             = 0xA1
+	ebmlSimpleBlock       = 0xA3
+)
+
+const ebmlUnknownSize = -1
+
+// limits of the elements read in memory, the bigger ones are invalid.
+// An opus packet is at most 120 ms of 1275 bytes frames, the blocks of the other tracks are skipped without reading them
+const (
+	maxWebmFieldSize = 1 << 10
+	maxWebmBlockSize = 64 << 10
+)
+
+var errNoOpusTrack = errors.New("no opus track")
+
+// webmTrack is a track of a webm file
+type webmTrack struct {
+	number    uint64
+	codec     string
+	frequency float64
+	channels  uint64
+}
+
+// webmDemuxer reads the opus packets of a webm (matroska) file, without decoding them.
+// Only the elements needed are parsed, the others are skipped, so it works on streams that can't seek
+type webmDemuxer struct {
+	r      *bufio.Reader
+	tracks []*webmTrack
+	opus   *webmTrack // track played, found at the first block
+}
+
+func newWebmDemuxer(r *bufio.Reader) *webmDemuxer {
+	return &webmDemuxer{r: r}
+}
+
+// Packet returns the next opus packet of the file
+func (d *webmDemuxer) Packet() (packet []byte, err error) {
+	for {
+		id, size, err := d.element()
+		if err != nil {
+			return nil, err
+		}
+
+		switch id {
+		case ebmlSegment, ebmlCluster, ebmlTracks, ebmlBlockGroup, ebmlAudio:
+			continue // the children are read as if they were at the top level
+		case ebmlTrackEntry:
+			d.tracks = append(d.tracks, &webmTrack{})
+			continue
+		}
+
+		if size == ebmlUnknownSize {
+			return nil, errors.New("webm element of unknown size")
+		}
+
+		switch id {
+		case ebmlTrackNumber, ebmlCodecID, ebmlSamplingFrequency, ebmlChannels:
+			if size > maxWebmFieldSize {
+				return nil, errors.New("webm track field too big")
+			}
+			data, err := d.read(size)
+			if err != nil {
+				return nil, err
+			}
+			d.setTrackField(id, data)
+		case ebmlSimpleBlock, ebmlBlock:
+			packet, ok, err := d.blockPacket(size)
+			if err != nil || ok {
+				return packet, err
+			}
+		default:
+			if _, err = d.r.Discard(int(size)); err != nil {
+				return nil, unexpectedEOF(err)
+			}
+		}
+	}
+}
+
+// read reads the data of an element
+func (d *webmDemuxer) read(size int64) (data []byte, err error) {
+	data = make([]byte, size)
+	if _, err = io.ReadFull(d.r, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return data, nil
+}
+
+func (d *webmDemuxer) setTrackField(id uint32, data []byte) {
+	if len(d.tracks) == 0 {
+		return
+	}
+	track := d.tracks[len(d.tracks)-1]
+
+	switch id {
+	case ebmlTrackNumber:
+		track.number = ebmlUint(data)
+	case ebmlCodecID:
+		track.codec = string(data)
+	case ebmlSamplingFrequency:
+		track.frequency = ebmlFloat(data)
+	case ebmlChannels:
+		track.channels = ebmlUint(data)
+	}
+}
+
+// blockPacket reads the block of `size` bytes and returns its packet, if it belongs to the opus track.
+// The blocks of the other tracks are skipped
+func (d *webmDemuxer) blockPacket(size int64) (packet []byte, res bool, err error) {
+	if d.opus == nil {
+		for _, track := range d.tracks {
+			if track.codec == "A_OPUS" {
+				d.opus = track
+				break
+			}
+		}
+		if d.opus == nil {
+			return nil, false, errNoOpusTrack
+		}
+	}
+
+	// the track number is at the start of the block
+	header, err := d.r.Peek(int(min(size, 8)))
+	if len(header) == 0 {
+		return nil, false, unexpectedEOF(err)
+	}
+	track, n := ebmlVint(header)
+	if n == 0 || size < int64(n+3) {
+		return nil, false, errors.New("invalid webm block")
+	}
+	if track != d.opus.number {
+		if _, err = d.r.Discard(int(size)); err != nil {
+			return nil, false, unexpectedEOF(err)
+		}
+		return nil, false, nil
+	}
+	if size > maxWebmBlockSize {
+		return nil, false, errors.New("webm block too big")
+	}
+
+	data, err := d.read(size)
+	if err != nil {
+		return nil, false, err
+	}
+
+	flags := data[n+2]
+	if flags&0x06 != 0 {
+		return nil, false, errors.New("laced webm blocks are not supported")
+	}
+	return data[n+3:], true, nil
+}
+
+// Track returns the opus track, known after the first packet
+func (d *webmDemuxer) Track() *webmTrack {
+	return d.opus
+}
+
+// element reads the id and size of the next element
+func (d *webmDemuxer) element() (id uint32, size int64, err error) {
+	first, err := d.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	length := vintLength(first)
+	if length == 0 || length > 4 {
+		return 0, 0, errors.New("invalid webm element id")
+	}
+	id = uint32(first)
+	for j := 1; j < length; j++ {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return 0, 0, unexpectedEOF(err)
+		}
+		id = id<<8 | uint32(b)
+	}
+
+	first, err = d.r.ReadByte()
+	if err != nil {
+		return 0, 0, unexpectedEOF(err)
+	}
+	length = vintLength(first)
+	if length == 0 {
+		return 0, 0, errors.New("invalid webm element size")
+	}
+	value := uint64(first) & (0xFF >> length)
+	unknown := value == 0xFF>>length
+	for j := 1; j < length; j++ {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return 0, 0, unexpectedEOF(err)
+		}
+		value = value<<8 | uint64(b)
+		unknown = unknown && b == 0xFF
+	}
+
+	if unknown {
+		return id, ebmlUnknownSize, nil
+	}
+	if value > math.MaxInt32 {
+		return 0, 0, errors.New("webm element too big")
+	}
+	return id, int64(value), nil
+}
+
+// vintLength returns the length of a variable size integer from its first byte, 0 if invalid
+func vintLength(first byte) int {
+	for j := 0; j < 8; j++ {
+		if first&(0x80>>j) != 0 {
+			return j + 1
+		}
+	}
+	return 0
+}
+
+// ebmlVint reads a variable size integer at the start of data, and returns it with its length
+func ebmlVint(data []byte) (value uint64, length int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	length = vintLength(data[0])
+	if length == 0 || len(data) < length {
+		return 0, 0
+	}
+	value = uint64(data[0]) & (0xFF >> length)
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+	return value, length
+}
+
+func ebmlUint(data []byte) (value uint64) {
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,190 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os/exec"
+	"testing"
+
+	"github.com/matthew-balzan/dca"
+)
+
+// opusSilence is a packet of 20 ms of silence
+var opusSilence = []byte{0xF8, 0xFF, 0xFE}
+
+// ebmlElement returns the element with the id and the data, its size written on 8 bytes
+func ebmlElement(id uint32, data ...[]byte) []byte {
+	var element []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(element) > 0 {
+			element = append(element, b)
+		}
+	}
+
+	content := bytes.Join(data, nil)
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(content)))
+	size[0] = 0x01
+	return append(append(element, size...), content...)
+}
+
+// webmBlock returns a simple block of the track
+func webmBlock(track byte, payload []byte) []byte {
+	return ebmlElement(ebmlSimpleBlock, []byte{0x80 | track, 0, 0, 0x80}, payload)
+}
+
+// webmFile returns a webm file with an opus track and a video track, with the blocks in a single cluster
+func webmFile(blocks ...[]byte) []byte {
+	frequency := make([]byte, 8)
+	binary.BigEndian.PutUint64(frequency, math.Float64bits(48000))
+
+	tracks := ebmlElement(ebmlTracks,
+		ebmlElement(ebmlTrackEntry,
+			ebmlElement(ebmlTrackNumber, []byte{1}),
+			ebmlElement(ebmlCodecID, []byte("V_VP9")),
+		),
+		ebmlElement(ebmlTrackEntry,
+			ebmlElement(ebmlTrackNumber, []byte{2}),
+			ebmlElement(ebmlCodecID, []byte("A_OPUS")),
+			ebmlElement(ebmlAudio,
+				ebmlElement(ebmlSamplingFrequency, frequency),
+				ebmlElement(ebmlChannels, []byte{2}),
+			),
+		),
+	)
+
+	header := ebmlElement(0x1A45DFA3, ebmlElement(0x4282, []byte("webm")))
+	segment := ebmlElement(ebmlSegment, tracks, ebmlElement(ebmlCluster, append([][]byte{ebmlElement(0xE7, []byte{0})}, blocks...)...))
+	return append(header, segment...)
+}
+
+// opusWebm returns a webm file with `packets` packets of silence
+func opusWebm(packets int) []byte {
+	blocks := make([][]byte, packets)
+	for j := range blocks {
+		blocks[j] = webmBlock(2, opusSilence)
+	}
+	return webmFile(blocks...)
+}
+
+func TestWebmDemuxer(t *testing.T) {
+	tests := []struct {
+		name    string
+		blocks  [][]byte
+		packets int
+		err     bool
+	}{
+		{"opus", [][]byte{webmBlock(2, opusSilence), webmBlock(2, opusSilence)}, 2, false},
+		{"video skipped", [][]byte{webmBlock(1, make([]byte, 100)), webmBlock(2, opusSilence), webmBlock(1, make([]byte, 100))}, 1, false},
+		{"big video skipped", [][]byte{webmBlock(1, make([]byte, 4*maxWebmBlockSize)), webmBlock(2, opusSilence)}, 1, false},
+		{"opus block too big", [][]byte{webmBlock(2, opusSilence), webmBlock(2, make([]byte, maxWebmBlockSize))}, 1, true},
+		{"truncated", [][]byte{webmBlock(2, opusSilence), webmBlock(2, opusSilence)[:6]}, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			demuxer := newWebmDemuxer(bufio.NewReader(bytes.NewReader(webmFile(test.blocks...))))
+
+			packets := 0
+			var err error
+			for {
+				var packet []byte
+				if packet, err = demuxer.Packet(); err != nil {
+					break
+				}
+				if !bytes.Equal(packet, opusSilence) {
+					t.Fatalf("packet %x, want %x", packet, opusSilence)
+				}
+				packets++
+			}
+
+			if failed := err != io.EOF; failed != test.err {
+				t.Fatalf("ended with %v", err)
+			}
+			if packets != test.packets {
+				t.Fatalf("%d packets, want %d", packets, test.packets)
+			}
+			if track := demuxer.Track(); track == nil || track.number != 2 || track.frequency != 48000 || track.channels != 2 {
+				t.Fatalf("opus track %+v", track)
+			}
+		})
+	}
+}
+
+func passthroughOptions() *dca.EncodeOptions {
+	options := *dca.StdEncodeOptions
+	options.RawOutput = true
+	options.Volume = 1
+	options.FrameRate = 48000
+	options.FrameDuration = 20
+	return &options
+}
+
+func TestPassthroughEncoder(t *testing.T) {
+	frames, err := PassthroughEncoder{Fallback: FfmpegEncoder{}}.Encode(bytes.NewReader(opusWebm(50)), passthroughOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer frames.Stop()
+
+	count := 0
+	for {
+		frame, err := frames.OpusFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame, opusSilence) {
+			t.Fatalf("frame %x, want the packet of the file", frame)
+		}
+		count++
+	}
+
+	if count != 50 {
+		t.Fatalf("%d frames, want 50", count)
+	}
+	if frames.FrameDuration() != passthroughFrameDuration {
+		t.Fatalf("frame duration %s", frames.FrameDuration())
+	}
+}
+
+// benchmarkEncoder reads all the frames of a minute of opus in webm
+func benchmarkEncoder(b *testing.B, encoder Encoder, options *dca.EncodeOptions) {
+	data := opusWebm(3000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		frames, err := encoder.Encode(bytes.NewReader(data), options)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			if _, err = frames.OpusFrame(); err != nil {
+				break
+			}
+		}
+		frames.Stop()
+		if err != io.EOF {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPassthrough and BenchmarkFfmpegEncoder time the two ways of playing an opus song.
+// The CPU of ffmpeg is not counted: `eido bench` compares the CPU of both
+func BenchmarkPassthrough(b *testing.B) {
+	benchmarkEncoder(b, PassthroughEncoder{Fallback: FfmpegEncoder{}}, passthroughOptions())
+}
+
+func BenchmarkFfmpegEncoder(b *testing.B) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		b.Skip("ffmpeg not installed")
+	}
+	benchmarkEncoder(b, FfmpegEncoder{}, passthroughOptions())
+}
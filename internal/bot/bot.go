@@ -1,17 +1,27 @@
 package bot
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/commands"
+	"github.com/matthew-balzan/eido/internal/discord"
 	"github.com/matthew-balzan/eido/internal/handlers"
+	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/utils"
+	"github.com/matthew-balzan/eido/internal/vars"
 )
 
 type Bot struct {
 	session *discordgo.Session
+	appId   string
+	guilds  []string // scopes where the commands are registered, an empty string is the global scope
 }
 
 func NewBot(session *discordgo.Session) *Bot {
@@ -23,78 +33,7 @@ func NewBot(session *discordgo.Session) *Bot {
 func (b *Bot) RegisterCommands(session *discordgo.Session) {
 
 	// Register the slash commands
-	commands := []*discordgo.ApplicationCommand{
-		{
-			Name:        "ping",
-			Description: "pong",
-		},
-		// {
-		// 	Name:        "bet",
-		// 	Description: "Create a bet",
-		// 	Options: []*discordgo.ApplicationCommandOption{
-		// 		{
-		// 			Type:        discordgo.ApplicationCommandOptionString,
-		// 			Name:        "title",
-		// 			Description: "Title of the bet",
-		// 			Required:    true,
-		// 		},
-		// 		{
-		// 			Type:        discordgo.ApplicationCommandOptionString,
-		// 			Name:        "choice1",
-		// 			Description: "Choice 1",
-		// 			Required:    true,
-		// 		},
-		// 		{
-		// 			Type:        discordgo.ApplicationCommandOptionString,
-		// 			Name:        "choice2",
-		// 			Description: "Choice 2",
-		// 			Required:    true,
-		// 		},
-		// 	},
-		// },
-		{
-			Name:        "play",
-			Description: "Adds a song or playlist to the queue. Supports youtube links, spotify links and youtube search",
-			Options: []*discordgo.ApplicationCommandOption{
-				{
-					Type:        discordgo.ApplicationCommandOptionString,
-					Name:        "input",
-					Description: "Url of the song or search input",
-					Required:    true,
-				},
-				{
-					Type:        discordgo.ApplicationCommandOptionInteger,
-					Name:        "skip-playlist",
-					Description: "Number of songs to skip in case the input is a playlist",
-					Required:    false,
-				},
-			},
-		},
-		{
-			Name:        "skip",
-			Description: "Skips the current song",
-		},
-		{
-			Name:        "pause",
-			Description: "Pause the current song",
-		},
-		{
-			Name:        "resume",
-			Description: "Resume the current song",
-		},
-		{
-			Name:        "disconnect",
-			Description: "Disconnects the bot from the voice channel",
-		},
-		{
-			Name:        "clear",
-			Description: "Clear the queue",
-		},
-		{
-			Name:        "queue",
-			Description: "Lists the songs in the queue",
-		},
-	}
+	definitions := commands.ApplicationCommands()
 
 	app, err := session.Application("@me")
 	if err != nil {
@@ -102,24 +41,138 @@ func (b *Bot) RegisterCommands(session *discordgo.Session) {
 		return
 	}
 
-	_, err = session.ApplicationCommandBulkOverwrite(app.ID, "", commands)
+	b.appId = app.ID
+	b.guilds = CommandScopes(vars.Config())
+
+	for _, guildId := range b.guilds {
+		err = SyncCommands(session, app.ID, guildId, definitions)
+		if err != nil {
+			log.Println("Error registering slash commands "+scopeName(guildId)+":", err)
+			return
+		}
+	}
+
+	log.Println("Slash commands registered!")
+}
+
+// CommandScopes returns where the commands are registered: the dev guilds in the dev environment, globally otherwise.
+// Global commands can take up to an hour to show up, guild commands are immediate
+func CommandScopes(configs *models.Config) []string {
+	if configs.Env == "dev" && len(configs.DevGuilds) > 0 {
+		return configs.DevGuilds
+	}
+	return []string{""}
+}
+
+// cleanupCommands deletes the commands registered by the bot, if enabled in the config
+func (b *Bot) cleanupCommands() {
+	if !vars.Config().CleanupCommandsOnShutdown || b.appId == "" {
+		return
+	}
+
+	for _, guildId := range b.guilds {
+		err := PurgeCommands(b.session, b.appId, guildId)
+		if err != nil {
+			log.Println("Error deleting slash commands "+scopeName(guildId)+":", err)
+		}
+	}
+
+	log.Println("Slash commands deleted")
+}
+
+// LoadState restores the settings of the servers saved by the last run
+func (b *Bot) LoadState() {
+	state, err := commands.ReadStateFile(vars.Config().StateFile)
 	if err != nil {
-		log.Println("Error registering slash commands:", err)
+		log.Println("ERR: internal/bot/bot.go: Error reading the state - ", err)
 		return
 	}
 
-	log.Println("Slash commands registered!")
+	vars.Instances.ImportState(state)
+	log.Println("State loaded!")
+}
+
+// saveState saves the settings of the servers for the next run, the ones that don't answer before the context ends are not saved
+func (b *Bot) saveState(ctx context.Context) {
+	err := commands.WriteStateFile(vars.Config().StateFile, vars.Instances.ExportState(ctx))
+	if err != nil {
+		log.Println("ERR: internal/bot/bot.go: Error saving the state - ", err)
+		return
+	}
+
+	log.Println("State saved")
+}
+
+// StartMetricsLog logs the metrics periodically, if enabled in the config.
+// The interval is read again after every log, so it follows the reloads of the config
+func (b *Bot) StartMetricsLog() {
+	go func() {
+		for {
+			interval := time.Duration(vars.Config().MetricsLogIntervalSeconds) * time.Second
+			if interval == 0 {
+				time.Sleep(10 * time.Second) // disabled, check again later
+				continue
+			}
+
+			time.Sleep(interval)
+			log.Println("Metrics:", commands.CollectMetrics())
+		}
+	}()
 }
 
 func (b *Bot) RegisterHandlers() {
 	b.session.AddHandler(handlers.InteractionCreate)
+	b.session.AddHandler(handlers.VoiceStateUpdate)
+	b.session.AddHandler(handlers.VoiceServerUpdate)
+	b.session.AddHandler(handlers.Resumed)
+	b.session.AddHandler(handlers.Ready)
+	b.session.AddHandler(handlers.GuildCreate)
 }
 
+// WaitForTermination waits for a termination signal and then shuts down the bot.
+// SIGHUP reloads the config instead
 func (b *Bot) WaitForTermination() {
-	// Wait here until terminaion
 	sc := make(chan os.Signal, 1)
-	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
-	<-sc
+	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, os.Interrupt)
+
+	for sig := range sc {
+		if sig == syscall.SIGHUP {
+			log.Println("SIGHUP received, reloading the config")
+			utils.ReloadConfig()
+			continue
+		}
+		break
+	}
+
+	b.shutdown()
+}
+
+// shutdown stops the bot: the commands are refused, the queues are saved and the active servers are told,
+// then the songs and their downloads are stopped and the bot leaves the voice channels.
+// It waits for all of this at most ShutdownTimeoutSeconds
+func (b *Bot) shutdown() {
+	log.Println("Shutting down")
+	handlers.StopInteractions()
+
+	timeout := time.Duration(models.ShutdownTimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// a stuck server takes at most half of the time from saving, so the others still have time to stop
+	saveCtx, cancelSave := context.WithTimeout(ctx, timeout/2)
+	b.saveState(saveCtx)
+	cancelSave()
+
+	vars.Instances.StopSessions(ctx, discord.NewSession(b.session))
+	if err := audio.CloseStreams(ctx); err != nil {
+		log.Println("ERR: internal/bot/bot.go: Error waiting for the streams to close - ", err)
+	}
+	if err := commands.WaitSessions(ctx); err != nil {
+		log.Println("ERR: internal/bot/bot.go: Error waiting for the sessions to end - ", err)
+	}
+
+	b.cleanupCommands()
 
 	b.session.Close()
+	log.Println("Bot stopped")
 }
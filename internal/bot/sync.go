@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// SyncCommands makes the commands registered on discord match the local definitions.
+// Only the commands that changed are written, the ones not defined anymore are deleted.
+// An empty `guildId` syncs the global commands
+func SyncCommands(session *discordgo.Session, appId string, guildId string, definitions []*discordgo.ApplicationCommand) (err error) {
+	remote, err := session.ApplicationCommands(appId, guildId)
+	if err != nil {
+		return err
+	}
+
+	remoteByName := make(map[string]*discordgo.ApplicationCommand, len(remote))
+	for _, c := range remote {
+		remoteByName[c.Name] = c
+	}
+
+	changes := 0
+
+	for _, local := range definitions {
+		existing := remoteByName[local.Name]
+		delete(remoteByName, local.Name)
+
+		switch {
+		case existing == nil:
+			_, err = session.ApplicationCommandCreate(appId, guildId, local)
+			log.Println("Command created: " + local.Name)
+		case !commandsEqual(local, existing, guildId == ""):
+			_, err = session.ApplicationCommandEdit(appId, guildId, existing.ID, local)
+			log.Println("Command updated: " + local.Name)
+		default:
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+		changes++
+	}
+
+	for _, stale := range remoteByName {
+		if err = session.ApplicationCommandDelete(appId, guildId, stale.ID); err != nil {
+			return err
+		}
+		log.Println("Command deleted: " + stale.Name)
+		changes++
+	}
+
+	if changes == 0 {
+		log.Println("Slash commands already up to date " + scopeName(guildId))
+	}
+
+	return nil
+}
+
+// PurgeCommands deletes all the commands registered on discord.
+// An empty `guildId` purges the global commands
+func PurgeCommands(session *discordgo.Session, appId string, guildId string) (err error) {
+	_, err = session.ApplicationCommandBulkOverwrite(appId, guildId, []*discordgo.ApplicationCommand{})
+	return err
+}
+
+func scopeName(guildId string) string {
+	if guildId == "" {
+		return "(global)"
+	}
+	return "(guild " + guildId + ")"
+}
+
+// commandsEqual returns true if the remote command is the same as the local definition.
+// The dm permission is compared only for global commands, since guild commands don't have it
+func commandsEqual(local *discordgo.ApplicationCommand, remote *discordgo.ApplicationCommand, global bool) bool {
+	if local.Name != remote.Name || local.Description != remote.Description {
+		return false
+	}
+
+	if global && boolOrTrue(local.DMPermission) != boolOrTrue(remote.DMPermission) {
+		return false
+	}
+
+	if !pointersEqual(local.DefaultMemberPermissions, remote.DefaultMemberPermissions) ||
+		!maps.Equal(mapOrNil(local.NameLocalizations), mapOrNil(remote.NameLocalizations)) ||
+		!maps.Equal(mapOrNil(local.DescriptionLocalizations), mapOrNil(remote.DescriptionLocalizations)) {
+		return false
+	}
+
+	return slices.EqualFunc(local.Options, remote.Options, optionsEqual)
+}
+
+func optionsEqual(a *discordgo.ApplicationCommandOption, b *discordgo.ApplicationCommandOption) bool {
+	if a.Type != b.Type || a.Name != b.Name || a.Description != b.Description || a.Required != b.Required || a.Autocomplete != b.Autocomplete {
+		return false
+	}
+
+	if !pointersEqual(a.MinValue, b.MinValue) || a.MaxValue != b.MaxValue || !pointersEqual(a.MinLength, b.MinLength) || a.MaxLength != b.MaxLength {
+		return false
+	}
+
+	if !slices.Equal(a.ChannelTypes, b.ChannelTypes) || !maps.Equal(a.NameLocalizations, b.NameLocalizations) || !maps.Equal(a.DescriptionLocalizations, b.DescriptionLocalizations) {
+		return false
+	}
+
+	choicesEqual := slices.EqualFunc(a.Choices, b.Choices, func(x, y *discordgo.ApplicationCommandOptionChoice) bool {
+		return x.Name == y.Name && fmt.Sprint(x.Value) == fmt.Sprint(y.Value) && // numbers come back from discord as float64
+			maps.Equal(x.NameLocalizations, y.NameLocalizations)
+	})
+
+	return choicesEqual && slices.EqualFunc(a.Options, b.Options, optionsEqual)
+}
+
+func boolOrTrue(b *bool) bool {
+	return b == nil || *b
+}
+
+// pointersEqual returns true if both are nil, or if they point to the same value
+func pointersEqual[T comparable](a *T, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func mapOrNil(m *map[discordgo.Locale]string) map[discordgo.Locale]string {
+	if m == nil {
+		return nil
+	}
+	return *m
+}
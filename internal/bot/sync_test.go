@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestCommandsEqual(t *testing.T) {
+	one, ten := 1.0, 10.0
+	permissions := int64(discordgo.PermissionManageServer)
+	localized := map[discordgo.Locale]string{discordgo.Italian: "suona"}
+
+	command := func(change func(c *discordgo.ApplicationCommand, option *discordgo.ApplicationCommandOption)) *discordgo.ApplicationCommand {
+		option := &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionInteger,
+			Name:        "minutes",
+			Description: "Minutes",
+			MinValue:    &one,
+			MaxValue:    60,
+		}
+		c := &discordgo.ApplicationCommand{
+			Name:        "idle",
+			Description: "Idle timeout",
+			Options:     []*discordgo.ApplicationCommandOption{option},
+		}
+		if change != nil {
+			change(c, option)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *discordgo.ApplicationCommand, option *discordgo.ApplicationCommandOption)
+		equal  bool
+	}{
+		{"same", nil, true},
+		{"description", func(c *discordgo.ApplicationCommand, _ *discordgo.ApplicationCommandOption) { c.Description = "other" }, false},
+		{"min value", func(_ *discordgo.ApplicationCommand, o *discordgo.ApplicationCommandOption) { o.MinValue = &ten }, false},
+		{"min value removed", func(_ *discordgo.ApplicationCommand, o *discordgo.ApplicationCommandOption) { o.MinValue = nil }, false},
+		{"max value", func(_ *discordgo.ApplicationCommand, o *discordgo.ApplicationCommandOption) { o.MaxValue = 120 }, false},
+		{"max length", func(_ *discordgo.ApplicationCommand, o *discordgo.ApplicationCommandOption) { o.MaxLength = 100 }, false},
+		{"channel types", func(_ *discordgo.ApplicationCommand, o *discordgo.ApplicationCommandOption) {
+			o.ChannelTypes = []discordgo.ChannelType{discordgo.ChannelTypeGuildVoice}
+		}, false},
+		{"option localizations", func(_ *discordgo.ApplicationCommand, o *discordgo.ApplicationCommandOption) {
+			o.NameLocalizations = localized
+		}, false},
+		{"command localizations", func(c *discordgo.ApplicationCommand, _ *discordgo.ApplicationCommandOption) {
+			c.NameLocalizations = &localized
+		}, false},
+		{"member permissions", func(c *discordgo.ApplicationCommand, _ *discordgo.ApplicationCommandOption) {
+			c.DefaultMemberPermissions = &permissions
+		}, false},
+		{"remote ids", func(c *discordgo.ApplicationCommand, _ *discordgo.ApplicationCommandOption) {
+			c.ID = "123"
+			c.Version = "456"
+		}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := commandsEqual(command(nil), command(test.change), true); got != test.equal {
+				t.Fatalf("commandsEqual = %v, want %v", got, test.equal)
+			}
+		})
+	}
+}
@@ -1,42 +1,29 @@
 package commands
 
 import (
-	"context"
-	"log"
+	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
 	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/youtube"
 	"golang.org/x/net/html"
-	"google.golang.org/api/option"
-	"google.golang.org/api/youtube/v3"
 )
 
 // getAudioChannel returns the channelId.
 // Returns an empty string if not found
-func getAudioChannel(s *discordgo.Session, i *discordgo.InteractionCreate) (audioChannel string) {
-	audioChannel = ""
-
-	channels := s.State.Guilds
-
-	for _, c := range channels {
-		voiceStates := c.VoiceStates
-		for _, v := range voiceStates {
-			if v.UserID == i.Member.User.ID {
-				audioChannel = v.ChannelID
-			}
-		}
-	}
-
-	return audioChannel
+func getAudioChannel(s discord.Session, i *discordgo.InteractionCreate) (audioChannel string) {
+	return s.UserVoiceChannel(i.GuildID, i.Member.User.ID)
 }
 
 // checkAudioBasicPrerequisites returns false if we don't have the requisites, true otherwise.
 // If it returns false and `response` is set to true, it automatically writes the error back to the user
-func checkAudioBasicPrerequisites(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance, channelId string, response bool) (res bool) {
+func checkAudioBasicPrerequisites(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, channelId string, response bool) (res bool) {
 	// if the user is not in a voice channel
 	if channelId == "" {
 		if response {
@@ -45,7 +32,7 @@ func checkAudioBasicPrerequisites(s *discordgo.Session, i *discordgo.Interaction
 		return false
 	}
 	// if the bot is in another channel
-	if instance.Voice.Connection != nil && instance.Voice.Connection.ChannelID != channelId {
+	if instance.Voice.Connection != nil && instance.Voice.Connection.ChannelID() != channelId {
 		if response {
 			SendSimpleMessageResponse(s, i, "I'm playing in another channel", models.ColorError)
 		}
@@ -56,7 +43,7 @@ func checkAudioBasicPrerequisites(s *discordgo.Session, i *discordgo.Interaction
 
 // isBotInAChannel returns false if it's not, true otherwise.
 // If it returns false and `response` is set to true, it automatically writes the error back to the user
-func isBotInAChannel(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance, response bool) (res bool) {
+func isBotInAChannel(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, response bool) (res bool) {
 	// if the bot is not in a channel
 	if instance.Voice.Connection == nil {
 		if response {
@@ -69,7 +56,7 @@ func isBotInAChannel(s *discordgo.Session, i *discordgo.InteractionCreate, insta
 
 // isBotPlaying returns false if it's not, true otherwise.
 // If it returns false and `response` is set to true, it automatically writes the error back to the user
-func isBotPlaying(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance, response bool) (res bool) {
+func isBotPlaying(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, response bool) (res bool) {
 	// if the bot is not playing a song
 	if !instance.Voice.IsPlaying {
 		if response {
@@ -80,239 +67,178 @@ func isBotPlaying(s *discordgo.Session, i *discordgo.InteractionCreate, instance
 	return true
 }
 
-func PlayCommand(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config) {
-	options := i.ApplicationCommandData().Options
-
-	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
-	for _, opt := range options {
-		optionMap[opt.Name] = opt
-	}
+// PlayCommand is deferred: it runs outside the loop of the server while it looks up the songs and joins the voice channel.
+// It goes through the loop to read and change the state of the server
+func PlayCommand(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config) {
+	optionMap := parseOptions(i)
 
 	input := optionMap["input"].StringValue()
-	var skip uint64 = 0
-	if optionMap["skip-playlist"] != nil {
-		skip = optionMap["skip-playlist"].UintValue()
-	}
 
 	channelId := getAudioChannel(s, i)
 
-	if !checkAudioBasicPrerequisites(s, i, instance, channelId, true) {
+	ok := false
+	instance.Do(func() {
+		ok = checkAudioBasicPrerequisites(s, i, instance, channelId, true)
+	})
+	if !ok {
 		return
 	}
 
 	switch {
 	case strings.Contains(input, "/playlist?"):
-		playCommandPlaylist(s, i, instance, channelId, input, skip, configs.YoutubeKey)
+		playCommandPlaylist(s, i, instance, channelId, input, parsePlaylistRange(optionMap), configs)
 	case (strings.Contains(input, "youtube.com") || strings.Contains(input, "youtu.be")):
-		playCommandVideo(s, i, instance, channelId, input, configs.YoutubeKey)
+		playCommandVideo(s, i, instance, channelId, input, configs)
 	case strings.Contains(input, "spotify.com"):
-		title := getVideoTitleFromSpotify(input)
-		url := searchVideoUrl(title, configs.YoutubeKey)
-		playCommandVideo(s, i, instance, channelId, url, configs.YoutubeKey)
+		title, err := getVideoTitleFromSpotify(input)
+		if err != nil {
+			respondError(s, i, err, "song")
+			return
+		}
+		url, err := searchVideoUrl(instance.Voice.youtube(configs), title)
+		if err != nil {
+			respondError(s, i, err, "song")
+			return
+		}
+		playCommandVideo(s, i, instance, channelId, url, configs)
 	default:
-		url := searchVideoUrl(input, configs.YoutubeKey)
-		playCommandVideo(s, i, instance, channelId, url, configs.YoutubeKey)
+		url, err := searchVideoUrl(instance.Voice.youtube(configs), input)
+		if err != nil {
+			respondError(s, i, err, "song")
+			return
+		}
+		playCommandVideo(s, i, instance, channelId, url, configs)
 	}
 }
 
-func playCommandVideo(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance, channelId string, urlVideo string, key string) {
-
-	reg := `^.*(?:(?:youtu\.be\/|v\/|vi\/|u\/\w\/|embed\/|shorts\/)|(?:(?:watch)?\?v(?:i)?=|\&v(?:i)?=))([^#\&\?]*).*`
-	res := regexp.MustCompile(reg)
-	id := res.FindStringSubmatch(urlVideo)[1]
-
-	service, err := youtube.NewService(context.Background(), option.WithAPIKey(key))
-	if err != nil {
-		log.Fatalf("Error creating new YouTube client: %v", err)
-	}
-
-	call := service.Videos.List([]string{"contentDetails", "snippet"}).Id(id)
-	resYt, _ := call.Do()
+func playCommandVideo(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, channelId string, urlVideo string, configs *models.Config) {
 
-	var videoInfo VideoInfo
-	if resYt.Items[0] != nil {
-		videoInfo = VideoInfo{
-			ID:        resYt.Items[0].Id,
-			Title:     resYt.Items[0].Snippet.Title,
-			Author:    resYt.Items[0].Snippet.ChannelTitle,
-			Duration:  strings.ReplaceAll(resYt.Items[0].ContentDetails.Duration, "PT", ""),
-			Thumbnail: resYt.Items[0].Snippet.Thumbnails.Default.Url,
-		}
+	id := youtubeVideoID(urlVideo)
+	if id == "" {
+		respondError(s, i, errUnsupported, "video")
+		return
 	}
 
-	if err != nil || videoInfo.ID == "" {
-		SendSimpleMessageResponse(
-			s,
-			i,
-			"Couldn't fetch the video, check if the url is correct",
-			models.ColorError,
-		)
+	videoInfo, err := getVideo(instance.Voice.youtube(configs), id)
+	if err != nil {
+		respondError(s, i, err, "video")
 		return
 	}
 
-	if instance.Voice.Connection == nil { // if there's already a voice connection
-		instance.Voice.startAudioSession(s, i, channelId) //start a new session
+	if !instance.Voice.joinSession(s, i.GuildID, channelId, i.ChannelID, configs) {
+		SendSimpleMessageResponse(s, i, "Couldn't join the voice channel", models.ColorError)
+		return
 	}
 
 	song := Song{
-		url:       urlVideo,
-		videoInfo: videoInfo,
-	}
-
-	result := instance.Voice.addToQueue(song)
-
-	if result {
-		SendSimpleMessageResponse(
-			s,
-			i,
-			"*"+song.videoInfo.Title+"* added to queue",
-			models.ColorDefault,
-		)
-	} else {
-		SendSimpleMessageResponse(
-			s,
-			i,
-			"Couldnt add song to queue. Check if you went over the queue limit ("+strconv.Itoa(models.MaxQueueLength)+")",
-			models.ColorError,
-		)
+		url:           urlVideo,
+		videoInfo:     videoInfo,
+		requesterId:   i.Member.User.ID,
+		requesterName: i.Member.User.Username,
 	}
-}
-
-func playCommandPlaylist(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance, channelId string, urlPlaylist string, skip uint64, key string) {
-
-	list := []VideoInfo{}
-	page := ""
-
-	reg := `^.*?(?:v|list)=(.*?)(?:&|$)`
-	res := regexp.MustCompile(reg)
-	id := res.FindStringSubmatch(urlPlaylist)[1]
 
-	service, err := youtube.NewService(context.Background(), option.WithAPIKey(key))
-	if err != nil {
-		log.Fatalf("Error creating new YouTube client: %v", err)
-	}
+	instance.Do(func() {
+		// the other commands ran during the lookup
+		if !checkAudioBasicPrerequisites(s, i, instance, channelId, true) {
+			return
+		}
+		if instance.Voice.Queue == nil { // disconnected in the meantime
+			SendSimpleMessageResponse(s, i, "Couldn't join the voice channel", models.ColorError)
+			return
+		}
 
-	for cont := true; cont; {
-		call := service.PlaylistItems.List([]string{"contentDetails", "snippet"}).PlaylistId(id).MaxResults(50).PageToken(page)
-		resYt, _ := call.Do()
+		result := instance.Voice.addToQueue(song)
 
-		if err != nil || resYt.Items[0] == nil {
-			log.Println(err)
+		if result {
 			SendSimpleMessageResponse(
 				s,
 				i,
-				"Couldn't fetch playlist items. Check if it's public.",
+				"*"+song.videoInfo.Title+"* added to queue",
+				models.ColorDefault,
+			)
+		} else {
+			SendSimpleMessageResponse(
+				s,
+				i,
+				"Couldnt add song to queue. Check if you went over the queue limit ("+strconv.Itoa(models.MaxQueueLength)+")",
 				models.ColorError,
 			)
-			return
 		}
+	})
+}
 
-		if resYt.NextPageToken == "" {
-			cont = false
-		} else {
-			page = resYt.NextPageToken
-		}
+var regYoutubeVideo = regexp.MustCompile(`^.*(?:(?:youtu\.be\/|v\/|vi\/|u\/\w\/|embed\/|shorts\/)|(?:(?:watch)?\?v(?:i)?=|\&v(?:i)?=))([^#\&\?]*).*`)
+var regYoutubePlaylist = regexp.MustCompile(`^.*?(?:v|list)=(.*?)(?:&|$)`)
 
-		for _, v := range resYt.Items {
-			list = append(list, VideoInfo{
-				ID:        v.ContentDetails.VideoId,
-				Title:     v.Snippet.Title,
-				Author:    v.Snippet.ChannelTitle,
-				Duration:  "",
-				Thumbnail: v.Snippet.Thumbnails.Default.Url,
-			})
-		}
+// youtubeVideoID returns the id of the video of the url, an empty string if it's not a youtube video
+func youtubeVideoID(url string) string {
+	match := regYoutubeVideo.FindStringSubmatch(url)
+	if match == nil {
+		return ""
 	}
+	return match[1]
+}
 
-	if instance.Voice.Connection == nil { // if there's already a voice connection
-		instance.Voice.startAudioSession(s, i, channelId) //start a new session
+// youtubePlaylistID returns the id of the playlist of the url, an empty string if it's not a youtube playlist
+func youtubePlaylistID(url string) string {
+	match := regYoutubePlaylist.FindStringSubmatch(url)
+	if match == nil {
+		return ""
 	}
+	return match[1]
+}
 
-	SendSimpleMessageResponse(
-		s,
-		i,
-		"Adding playlist. It may take some time ...\n\n"+urlPlaylist,
-		models.ColorDefault,
-	)
-
-	globalError := false
-
-	for _, entry := range list {
-		if skip > 0 {
-			skip--
-			continue
-		}
-
-		if err != nil {
-			globalError = true
-		}
-
-		song := Song{
-			url:       "https://www.youtube.com/watch?v=" + entry.ID,
-			videoInfo: entry,
-		}
-
-		result := instance.Voice.addToQueue(song)
+var spotifyClient = &http.Client{Timeout: 10 * time.Second}
 
-		if !result {
-			globalError = true
-		}
+// getVideoTitleFromSpotify returns the title and the artist of the spotify song, read from the title of its page
+func getVideoTitleFromSpotify(input string) (title string, err error) {
+	res, err := spotifyClient.Get(input)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", youtube.ErrNetwork, err)
 	}
+	defer res.Body.Close()
 
-	if globalError {
-		SendSimpleMessage(
-			s,
-			i,
-			"Playlist added to queue, but one or more videos have not been added due to some errors. Check if you went over the limit of the queue ("+strconv.Itoa(models.MaxQueueLength)+")",
-			models.ColorError,
-		)
-	} else {
-		SendSimpleMessage(
-			s,
-			i,
-			"Playlist added to queue",
-			models.ColorDefault,
-		)
+	if res.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("spotify: %w", youtube.ErrNotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: spotify answered %s", youtube.ErrNetwork, res.Status)
 	}
-}
 
-func getVideoTitleFromSpotify(input string) (url string) {
-	res, _ := http.Get(input)
-	doc, _ := html.Parse(res.Body)
+	doc, err := html.Parse(res.Body)
+	if err != nil {
+		return "", err
+	}
 
-	title := doc.FirstChild.NextSibling.FirstChild.FirstChild.NextSibling.FirstChild.Data //TODO
+	title = strings.TrimSpace(htmlTitle(doc))
+	if title == "" {
+		return "", fmt.Errorf("spotify page without a title: %w", errUnsupported)
+	}
 
+	title = strings.ReplaceAll(title, "- song and lyrics by", "")
 	title = strings.ReplaceAll(title, "- song by", "")
 	title = strings.ReplaceAll(title, "| Spotify", "")
 
-	return title
+	return strings.TrimSpace(title), nil
 }
 
-func searchVideoUrl(input string, key string) (url string) {
-
-	service, err := youtube.NewService(context.Background(), option.WithAPIKey(key))
-	if err != nil {
-		log.Fatalf("Error creating new YouTube client: %v", err)
+// htmlTitle returns the text of the <title> of the page, an empty string if not found
+func htmlTitle(node *html.Node) string {
+	if node.Type == html.ElementNode && node.Data == "title" {
+		if node.FirstChild != nil {
+			return node.FirstChild.Data
+		}
+		return ""
 	}
-
-	// Make the API call to YouTube.
-	call := service.Search.List([]string{"id", "snippet"}).
-		Q(input).
-		MaxResults(5)
-	response, _ := call.Do()
-
-	// Iterate through each item and add it to the array if it's a video.
-	for _, item := range response.Items {
-		if item.Id.Kind == "youtube#video" {
-			// return the first video
-			return "https://www.youtube.com/watch?v=" + item.Id.VideoId
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if title := htmlTitle(child); title != "" {
+			return title
 		}
 	}
-
 	return ""
 }
 
-func Disconnect(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance) {
+func Disconnect(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, _ *models.Config) {
 	channelId := getAudioChannel(s, i)
 
 	if !isBotInAChannel(s, i, instance, true) {
@@ -328,7 +254,7 @@ func Disconnect(s *discordgo.Session, i *discordgo.InteractionCreate, instance *
 	instance.Voice.disconnect()
 }
 
-func SkipSong(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance) {
+func SkipSong(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config) {
 	channelId := getAudioChannel(s, i)
 
 	if !isBotInAChannel(s, i, instance, true) {
@@ -343,12 +269,24 @@ func SkipSong(s *discordgo.Session, i *discordgo.InteractionCreate, instance *Se
 		return
 	}
 
+	// requesters can always skip their own songs, the others have to vote
+	queue := instance.Voice.getQueueList()
+	isRequester := len(queue) > 0 && queue[0].requesterId == i.Member.User.ID
+
+	if !hasCommandPermission(s, i, FindCommand("skip"), configs) && !isRequester {
+		res, votes, needed := instance.Voice.voteSkip(s, i, configs)
+		if !res {
+			SendSimpleMessageResponse(s, i, voteSkipMessage(votes, needed), models.ColorDefault)
+			return
+		}
+	}
+
 	instance.Voice.skip()
 
 	SendSimpleMessageResponse(s, i, "Song has been skipped", models.ColorDefault)
 }
 
-func PauseSong(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance) {
+func PauseSong(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, _ *models.Config) {
 	channelId := getAudioChannel(s, i)
 
 	if !isBotInAChannel(s, i, instance, true) {
@@ -368,7 +306,7 @@ func PauseSong(s *discordgo.Session, i *discordgo.InteractionCreate, instance *S
 	SendSimpleMessageResponse(s, i, "Song has been paused", models.ColorDefault)
 }
 
-func ResumeSong(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance) {
+func ResumeSong(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, _ *models.Config) {
 	channelId := getAudioChannel(s, i)
 
 	if !isBotInAChannel(s, i, instance, true) {
@@ -388,7 +326,7 @@ func ResumeSong(s *discordgo.Session, i *discordgo.InteractionCreate, instance *
 	SendSimpleMessageResponse(s, i, "Song has been resumed", models.ColorDefault)
 }
 
-func ClearQueue(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance) {
+func ClearQueue(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, _ *models.Config) {
 	channelId := getAudioChannel(s, i)
 
 	if !isBotInAChannel(s, i, instance, true) {
@@ -404,7 +342,7 @@ func ClearQueue(s *discordgo.Session, i *discordgo.InteractionCreate, instance *
 	SendSimpleMessageResponse(s, i, "Queue cleared", models.ColorDefault)
 }
 
-func GetQueue(s *discordgo.Session, i *discordgo.InteractionCreate, instance *ServerInstance) {
+func GetQueue(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, _ *models.Config) {
 	channelId := getAudioChannel(s, i)
 
 	if !isBotInAChannel(s, i, instance, true) {
@@ -421,13 +359,43 @@ func GetQueue(s *discordgo.Session, i *discordgo.InteractionCreate, instance *Se
 	if len(queue) == 0 {
 		message = "Queue is empty"
 	} else {
+		// time until each song plays, known until a song without duration
+		var wait time.Duration
+		known := true
+
 		for i, song := range queue {
+			duration, ok := parseSongDuration(song.videoInfo.Duration)
+
 			row := strconv.Itoa(i) + ". " + song.videoInfo.Title
+			if ok {
+				row += " `" + formatDuration(duration) + "`"
+			}
+			if song.autoplay {
+				row += " *(autoplay)*"
+			} else {
+				row += " *(" + song.requestedBy() + ")*"
+			}
+
 			if i == 0 {
 				row += " -> Now playing"
+				if ok && instance.Voice.Stream != nil {
+					duration = max(0, duration-instance.Voice.playbackPosition())
+					row += ", " + formatDuration(duration) + " left"
+				}
+			} else if known {
+				row += " -> in " + formatDuration(wait)
 			}
 			message += row + " \n"
+
+			wait += duration
+			known = known && ok
+		}
+
+		total := formatDuration(wait)
+		if !known {
+			total = "more than " + total
 		}
+		message += "\n**Total:** " + strconv.Itoa(len(queue)) + " songs, " + total + " left"
 	}
 
 	SendSimpleMessageResponse(s, i, message, models.ColorDefault)
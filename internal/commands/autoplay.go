@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/youtube"
+)
+
+// AutoplayCommand enables or disables the autoplay mode.
+// Without the `enabled` option it toggles the current state
+func AutoplayCommand(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, _ *models.Config) {
+	enabled := !instance.Voice.Autoplay
+	if opt := parseOptions(i)["enabled"]; opt != nil {
+		enabled = opt.BoolValue()
+	}
+
+	instance.Voice.Autoplay = enabled
+
+	if enabled {
+		SendSimpleMessageResponse(s, i, "Autoplay enabled. When the queue ends I'll keep playing related songs", models.ColorDefault)
+	} else {
+		SendSimpleMessageResponse(s, i, "Autoplay disabled", models.ColorDefault)
+	}
+}
+
+// addToHistory saves the song in the history of the instance, used by autoplay to find related songs
+func (v *VoiceInstance) addToHistory(song Song) {
+	v.History = append(v.History, song)
+	if len(v.History) > models.MaxHistoryLength {
+		v.History = v.History[len(v.History)-models.MaxHistoryLength:]
+	}
+}
+
+// recentlyPlayed returns the ids of the last `window` songs of the history
+func recentlyPlayed(history []Song, window int) (ids map[string]bool) {
+	ids = make(map[string]bool, window)
+	for j := len(history) - 1; j >= 0 && len(history)-j <= window; j-- {
+		ids[history[j].videoInfo.ID] = true
+	}
+	return ids
+}
+
+// nextAutoplaySong returns a song related to the last ones of the history.
+// Candidates come from the youtube mix of the last song, and then from the history of the server.
+// Returns false if no song could be found.
+// It calls yt-dlp, so it runs outside the loop of the server on a copy of the history
+func nextAutoplaySong(history []Song, configs *models.Config) (song Song, res bool) {
+	if len(history) == 0 {
+		return song, false
+	}
+
+	last := history[len(history)-1]
+	return pickAutoplaySong(history, getMixCandidates(last.videoInfo.ID), configs.AutoplayRepeatWindow)
+}
+
+// pickAutoplaySong returns the first candidate not played in the last `window` songs of the history.
+// If they were all played, it falls back to a random song of the history out of the window
+func pickAutoplaySong(history []Song, candidates []VideoInfo, window int) (song Song, res bool) {
+	recent := recentlyPlayed(history, window)
+
+	for _, c := range candidates {
+		if !recent[c.ID] {
+			return newAutoplaySong(c), true
+		}
+	}
+
+	// fallback to the history, in random order
+	for _, j := range rand.Perm(len(history)) {
+		c := history[j].videoInfo
+		if !recent[c.ID] {
+			return newAutoplaySong(c), true
+		}
+	}
+
+	return song, false
+}
+
+func newAutoplaySong(videoInfo VideoInfo) Song {
+	return Song{
+		url:       "https://www.youtube.com/watch?v=" + videoInfo.ID,
+		videoInfo: videoInfo,
+		autoplay:  true,
+	}
+}
+
+// getMixCandidates returns the songs of the youtube mix generated from the video `id`.
+// The first entry of a mix is the video itself, so it's skipped
+func getMixCandidates(id string) (list []VideoInfo) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	url := "https://www.youtube.com/watch?v=" + id + "&list=RD" + id
+
+	videos, err := youtube.YtdlpVideos(ctx, url, 0, 25)
+	if err != nil {
+		log.Println("ERR: internal/commands/autoplay.go: Error fetching the mix - ", err)
+		return list
+	}
+
+	for _, video := range videos {
+		if video.ID != id {
+			list = append(list, VideoInfo(video))
+		}
+	}
+
+	return list
+}
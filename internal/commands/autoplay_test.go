@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"testing"
+)
+
+// historyOf returns a history with a song for each id, the last one played last
+func historyOf(ids ...string) (history []Song) {
+	for _, id := range ids {
+		history = append(history, Song{videoInfo: VideoInfo{ID: id}})
+	}
+	return history
+}
+
+func videosOf(ids ...string) (list []VideoInfo) {
+	for _, id := range ids {
+		list = append(list, VideoInfo{ID: id})
+	}
+	return list
+}
+
+func TestPickAutoplaySong(t *testing.T) {
+	tests := []struct {
+		name       string
+		history    []Song
+		candidates []VideoInfo
+		window     int
+		want       []string // songs that can be picked, none if nothing can be played
+	}{
+		{"first candidate", historyOf("a"), videosOf("b", "c"), 20, []string{"b"}},
+		{"recent candidate skipped", historyOf("b", "a"), videosOf("a", "b", "c"), 20, []string{"c"}},
+		{"candidate out of the window", historyOf("b", "x", "a"), videosOf("b", "c"), 2, []string{"b"}},
+		{"history fallback", historyOf("c", "b", "a"), videosOf("a", "b"), 2, []string{"c"}},
+		{"history fallback without candidates", historyOf("d", "c", "b", "a"), nil, 2, []string{"c", "d"}},
+		{"all recent", historyOf("b", "a"), videosOf("a", "b"), 20, nil},
+		{"window of zero", historyOf("a"), videosOf("a"), 0, []string{"a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the fallback is random, so it's tried a few times
+			for n := 0; n < 20; n++ {
+				song, ok := pickAutoplaySong(test.history, test.candidates, test.window)
+
+				if ok != (len(test.want) > 0) {
+					t.Fatalf("picked %q, %v", song.videoInfo.ID, ok)
+				}
+				if !ok {
+					return
+				}
+				if !song.autoplay || song.url != "https://www.youtube.com/watch?v="+song.videoInfo.ID {
+					t.Fatalf("song %+v not marked as autoplay", song)
+				}
+
+				allowed := false
+				for _, id := range test.want {
+					allowed = allowed || song.videoInfo.ID == id
+				}
+				if !allowed {
+					t.Fatalf("picked %q, want one of %v", song.videoInfo.ID, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecentlyPlayed(t *testing.T) {
+	history := historyOf("a", "b", "c", "b")
+
+	tests := []struct {
+		window int
+		want   []string
+	}{
+		{0, nil},
+		{1, []string{"b"}},
+		{2, []string{"b", "c"}},
+		{10, []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		recent := recentlyPlayed(history, test.window)
+		if len(recent) != len(test.want) {
+			t.Fatalf("window %d: %v, want %v", test.window, recent, test.want)
+		}
+		for _, id := range test.want {
+			if !recent[id] {
+				t.Fatalf("window %d: %v, want %v", test.window, recent, test.want)
+			}
+		}
+	}
+}
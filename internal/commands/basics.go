@@ -2,9 +2,10 @@ package commands
 
 import (
 	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
 )
 
-func SendSimpleMessageResponse(s *discordgo.Session, i *discordgo.InteractionCreate, message string, color int) {
+func SendSimpleMessageResponse(s discord.Responder, i *discordgo.InteractionCreate, message string, color int) {
 
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -19,7 +20,7 @@ func SendSimpleMessageResponse(s *discordgo.Session, i *discordgo.InteractionCre
 	})
 }
 
-func SendSimpleMessage(s *discordgo.Session, i *discordgo.InteractionCreate, message string, color int) {
+func SendSimpleMessage(s discord.Messenger, i *discordgo.InteractionCreate, message string, color int) {
 	s.ChannelMessageSendEmbeds(i.ChannelID, []*discordgo.MessageEmbed{
 		{
 			Description: message,
@@ -28,7 +29,16 @@ func SendSimpleMessage(s *discordgo.Session, i *discordgo.InteractionCreate, mes
 	})
 }
 
-func SendComplexMessageResponse(s *discordgo.Session, i *discordgo.InteractionCreate, title string, description string, urlImage string, footerText string, color int, author string) {
+func SendSimpleMessageToChannel(s discord.Messenger, channelId string, message string, color int) {
+	s.ChannelMessageSendEmbeds(channelId, []*discordgo.MessageEmbed{
+		{
+			Description: message,
+			Color:       color,
+		},
+	})
+}
+
+func SendComplexMessageResponse(s discord.Responder, i *discordgo.InteractionCreate, title string, description string, urlImage string, footerText string, color int, author string) {
 
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -53,9 +63,13 @@ func SendComplexMessageResponse(s *discordgo.Session, i *discordgo.InteractionCr
 	})
 }
 
-func SendComplexMessage(s *discordgo.Session, i *discordgo.InteractionCreate, title string, description string, urlImage string, footerText string, color int, author string) {
+func SendComplexMessage(s discord.Messenger, i *discordgo.InteractionCreate, title string, description string, urlImage string, footerText string, color int, author string) {
+	SendComplexMessageToChannel(s, i.ChannelID, title, description, urlImage, footerText, color, author)
+}
 
-	s.ChannelMessageSendEmbeds(i.ChannelID, []*discordgo.MessageEmbed{
+func SendComplexMessageToChannel(s discord.Messenger, channelId string, title string, description string, urlImage string, footerText string, color int, author string) {
+
+	s.ChannelMessageSendEmbeds(channelId, []*discordgo.MessageEmbed{
 		{
 			Title:       title,
 			Description: description,
@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// audioCache keeps the audio of the songs on disk, nil if disabled. It's opened at startup
+var audioCache *audio.Cache
+
+// warmingUp is true while the songs of a playlist are being saved in the cache
+var warmingUp atomic.Bool
+
+// OpenAudioCache opens the cache of the songs, if enabled in the config
+func OpenAudioCache(configs *models.Config) (err error) {
+	if configs.CacheMaxMB == 0 {
+		return nil
+	}
+
+	dir := configs.CacheDir
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(base, "eido", "audio")
+	}
+
+	audioCache, err = audio.OpenCache(dir, int64(configs.CacheMaxMB)<<20)
+	if err != nil {
+		return err
+	}
+
+	stats := audioCache.Stats()
+	log.Println("Audio cache opened in " + dir + " with " + fmt.Sprint(stats.Entries) + " songs (" + formatBytes(stats.Size) + ")")
+	return nil
+}
+
+// profileSource returns the source of the songs with the yt-dlp format of the profile, and the format used.
+// The songs are read from the cache, and the ones downloaded are saved in it
+func profileSource(source audio.Source, profile models.EncodingProfile) (res audio.Source, format string) {
+	if ytdlp, ok := source.(audio.YtdlpSource); ok {
+		if profile.Format != "" {
+			ytdlp.Format = profile.Format
+		}
+		source, format = ytdlp, ytdlp.Format
+	}
+	return cachedSource(source, format), format
+}
+
+// cachedSource reads the songs from the cache, and saves the ones downloaded from the source.
+// The songs are cached once for every format of yt-dlp, since the profiles download different audio
+func cachedSource(source audio.Source, format string) audio.Source {
+	if audioCache == nil {
+		return source
+	}
+	key := func(url string) string {
+		id := youtubeVideoID(url)
+		if id == "" {
+			return ""
+		}
+		return cacheKey(id, format)
+	}
+	return audio.CachedSource{Source: source, Cache: audioCache, Key: key}
+}
+
+// cacheKey returns the key of the song in the cache, downloaded with the format
+func cacheKey(id string, format string) string {
+	return id + " " + format
+}
+
+// CacheCommand shows the numbers of the cache, clears it, or saves the songs of a playlist in it
+func CacheCommand(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config) {
+	if audioCache == nil {
+		SendSimpleMessageResponse(s, i, "The audio cache is disabled", models.ColorError)
+		return
+	}
+
+	sub := i.ApplicationCommandData().Options[0]
+
+	switch sub.Name {
+	case "stats":
+		stats := audioCache.Stats()
+		ratio := 0.0
+		if stats.Hits+stats.Misses > 0 {
+			ratio = 100 * float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+		}
+		SendSimpleMessageResponse(
+			s,
+			i,
+			"**Songs:** "+fmt.Sprint(stats.Entries)+"\n"+
+				"**Size:** "+formatBytes(stats.Size)+" of "+formatBytes(stats.Limit)+"\n"+
+				"**Hits:** "+fmt.Sprint(stats.Hits)+", **misses:** "+fmt.Sprint(stats.Misses)+fmt.Sprintf(" (%.0f%% hits)", ratio),
+			models.ColorDefault,
+		)
+
+	case "clear":
+		if err := audioCache.Clear(); err != nil {
+			log.Println("ERR: internal/commands/cache.go: Error clearing the cache - ", err)
+			SendSimpleMessageResponse(s, i, "Couldn't delete some songs of the cache", models.ColorError)
+			return
+		}
+		SendSimpleMessageResponse(s, i, "Cache cleared", models.ColorDefault)
+
+	case "warmup":
+		url := sub.Options[0].StringValue()
+		id := youtubePlaylistID(url)
+		if id == "" {
+			SendSimpleMessageResponse(s, i, "That's not a youtube playlist", models.ColorError)
+			return
+		}
+		if !warmingUp.CompareAndSwap(false, true) {
+			SendSimpleMessageResponse(s, i, "Another playlist is being saved in the cache, try again later", models.ColorError)
+			return
+		}
+
+		SendSimpleMessageResponse(s, i, "Saving the songs of the playlist in the cache. It may take some time ...", models.ColorDefault)
+
+		lookup := instance.Voice.youtube(configs)
+		source := instance.Voice.Pipeline.Source
+
+		go func() {
+			defer warmingUp.Store(false)
+
+			saved, err := warmupPlaylist(lookup, source, id, configs)
+			if err != nil {
+				log.Println("ERR: internal/commands/cache.go: Error saving the playlist in the cache - ", err)
+				SendSimpleMessage(s, i, errorMessage(err, "playlist")+". Couldn't save the playlist in the cache, "+fmt.Sprint(saved)+" songs saved", models.ColorError)
+				return
+			}
+			SendSimpleMessage(s, i, "Playlist saved in the cache, "+fmt.Sprint(saved)+" new songs", models.ColorDefault)
+		}()
+	}
+}
+
+// WarmupCache saves in the cache the songs of the playlists of the config, in the background
+func WarmupCache(configs *models.Config) {
+	if audioCache == nil || len(configs.CacheWarmupPlaylists) == 0 {
+		return
+	}
+	if !warmingUp.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer warmingUp.Store(false)
+
+		for _, url := range configs.CacheWarmupPlaylists {
+			id := youtubePlaylistID(url)
+			if id == "" {
+				log.Println("ERR: internal/commands/cache.go: Not a youtube playlist - ", url)
+				continue
+			}
+
+			saved, err := warmupPlaylist(youtubeClient(configs), defaultPipeline().Source, id, configs)
+			if err != nil {
+				log.Println("ERR: internal/commands/cache.go: Error saving the playlist in the cache - ", err)
+			}
+			log.Println("Cache warmed up with " + fmt.Sprint(saved) + " new songs of " + url)
+		}
+	}()
+}
+
+// warmupPlaylist downloads in the cache the songs of the playlist not already there, one at a time.
+// It returns how many songs were saved
+func warmupPlaylist(lookup youtubeLookup, source audio.Source, id string, configs *models.Config) (saved int, err error) {
+	list, _, err := getPlaylistVideos(lookup, id)
+	if err != nil {
+		return 0, err
+	}
+
+	profile, _ := configs.Profile(configs.EncodingProfile)
+	source, format := profileSource(source, profile)
+
+	for _, video := range list {
+		if audioCache.Has(cacheKey(video.ID, format)) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+		r, err := source.Open(ctx, "https://www.youtube.com/watch?v="+video.ID)
+		if err == nil {
+			_, err = io.Copy(io.Discard, r)
+			if cerr := r.Close(); err == nil {
+				err = cerr
+			}
+		}
+		cancel()
+
+		if err != nil {
+			log.Println("ERR: internal/commands/cache.go: Error saving the song in the cache - ", err)
+			continue
+		}
+		saved++
+	}
+
+	return saved, nil
+}
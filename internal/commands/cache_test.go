@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/discord/fake"
+	"github.com/matthew-balzan/eido/internal/youtube"
+)
+
+// useAudioCache opens an empty audio cache for the test
+func useAudioCache(t *testing.T) {
+	cache, err := audio.OpenCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioCache = cache
+	t.Cleanup(func() {
+		audioCache = nil
+	})
+}
+
+// countingSource returns 100 bytes for every url, counting how many times it's opened
+func countingSource(opened *atomic.Int64) audio.Source {
+	return audio.ReaderSource(func(ctx context.Context, url string) (io.ReadCloser, error) {
+		opened.Add(1)
+		return io.NopCloser(strings.NewReader(strings.Repeat("a", 100))), nil
+	})
+}
+
+// readSong reads the whole song from the source
+func readSong(t *testing.T, source audio.Source, id string) {
+	t.Helper()
+
+	r, err := source.Open(context.Background(), "https://www.youtube.com/watch?v="+id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err = io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func adminCommand(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	i := slashCommand(name, "admin", options...)
+	i.Member.Permissions = discordgo.PermissionAdministrator
+	return i
+}
+
+func subCommand(name string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:    name,
+		Type:    discordgo.ApplicationCommandOptionSubCommand,
+		Options: options,
+	}
+}
+
+func TestCachedSourceFormats(t *testing.T) {
+	useAudioCache(t)
+
+	var opened atomic.Int64
+	source := countingSource(&opened)
+
+	readSong(t, cachedSource(source, "bestaudio"), testVideos[0].ID)
+	readSong(t, cachedSource(source, "bestaudio"), testVideos[0].ID)
+	if opened.Load() != 1 {
+		t.Fatalf("source opened %d times for the same format, want once", opened.Load())
+	}
+
+	// another profile downloads different audio
+	readSong(t, cachedSource(source, "worstaudio"), testVideos[0].ID)
+	if opened.Load() != 2 {
+		t.Fatalf("source opened %d times for two formats, want twice", opened.Load())
+	}
+	for _, format := range []string{"bestaudio", "worstaudio"} {
+		if !audioCache.Has(cacheKey(testVideos[0].ID, format)) {
+			t.Errorf("song not cached with the format %s", format)
+		}
+	}
+}
+
+func TestCacheCommand(t *testing.T) {
+	configs := testConfig()
+	useAudioCache(t)
+
+	s, instance := newTestServer(t, 10)
+	var opened atomic.Int64
+	instance.Voice.Pipeline.Source = countingSource(&opened)
+	instance.Voice.lookup = &fakeLookup{videos: testVideos, playlists: map[string][]youtube.Video{"PLtest": testVideos}}
+
+	// warmup runs the command and waits for the message at the end of the warmup
+	warmup := func(url string, end string) {
+		t.Helper()
+
+		i := adminCommand("cache", subCommand("warmup", stringOption("playlist", url)))
+		runCommand(s, instance, i, configs)
+		if got := response(t, s, i); !strings.Contains(got, "Saving the songs of the playlist") {
+			t.Fatalf("response %q", got)
+		}
+		waitFor(t, s, "end of the warmup", func(message fake.Message) bool {
+			return strings.HasSuffix(message.Embeds[0].Description, end) && !warmingUp.Load()
+		})
+	}
+
+	// the songs of the playlist are downloaded once
+	warmup("https://www.youtube.com/playlist?list=PLtest", "Playlist saved in the cache, 2 new songs")
+	if opened.Load() != 2 || audioCache.Stats().Entries != 2 {
+		t.Fatalf("source opened %d times, %d songs cached, want 2 and 2", opened.Load(), audioCache.Stats().Entries)
+	}
+
+	warmup("https://www.youtube.com/playlist?list=PLtest", "Playlist saved in the cache, 0 new songs")
+	if opened.Load() != 2 {
+		t.Fatalf("source opened %d times, the songs in the cache were downloaded again", opened.Load())
+	}
+
+	warmup("https://www.youtube.com/playlist?list=PLmissing", "Couldn't save the playlist in the cache, 0 songs saved")
+
+	i := adminCommand("cache", subCommand("warmup", stringOption("playlist", "not a playlist")))
+	runCommand(s, instance, i, configs)
+	if got := response(t, s, i); got != "That's not a youtube playlist" {
+		t.Fatalf("response %q", got)
+	}
+
+	i = adminCommand("cache", subCommand("clear"))
+	runCommand(s, instance, i, configs)
+	if got := response(t, s, i); got != "Cache cleared" {
+		t.Fatalf("response %q", got)
+	}
+	if stats := audioCache.Stats(); stats.Entries != 0 || stats.Size != 0 {
+		t.Fatalf("%d songs of %d bytes left after clearing the cache", stats.Entries, stats.Size)
+	}
+
+	// only admins manage the cache
+	i = slashCommand("cache", "user", subCommand("clear"))
+	runCommand(s, instance, i, configs)
+	if got := response(t, s, i); got != "Only admins can use this command" {
+		t.Fatalf("response %q", got)
+	}
+}
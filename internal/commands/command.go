@@ -0,0 +1,186 @@
+package commands
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// Handler runs a command. `instance` is nil when the command is used outside of a server
+type Handler func(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config)
+
+// Command declares a slash command. Its registration on discord, dispatch and checks are all derived from here
+type Command struct {
+	Name        string
+	Description string
+	Options     []*discordgo.ApplicationCommandOption
+	Permission  models.PermissionLevel // level needed to use the command, can be overridden with the COMMAND_PERMISSIONS config
+	Votable     bool                   // users without the permission can still use the command, to vote
+	Cooldown    time.Duration          // time a user has to wait between two uses
+	GuildOnly   bool                   // true if the command can't be used in direct messages
+	Deferred    bool                   // the command looks things up on the network: it's answered later, and its handler runs outside the loop of the server
+	Examples    []string               // shown in the help
+	Handler     Handler
+}
+
+// FindCommand returns the command with the name, nil if not found
+func FindCommand(name string) *Command {
+	for _, c := range Commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// ApplicationCommands returns the commands to register on discord
+func ApplicationCommands() (list []*discordgo.ApplicationCommand) {
+	for _, c := range Commands {
+		dmPermission := !c.GuildOnly
+		list = append(list, &discordgo.ApplicationCommand{
+			Name:         c.Name,
+			Description:  c.Description,
+			Options:      c.Options,
+			DMPermission: &dmPermission,
+		})
+	}
+	return list
+}
+
+// Run checks if the user can use the command and runs it.
+// The handler runs on the loop of the server, unless the command is deferred
+func (c *Command) Run(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config) {
+	if c.GuildOnly && instance == nil {
+		SendSimpleMessageResponse(s, i, "This command can only be used in a server", models.ColorError)
+		return
+	}
+
+	if !c.Votable && !CheckCommandPermission(s, i, c, configs) {
+		return
+	}
+
+	if !checkCooldown(s, i, c) {
+		return
+	}
+
+	if instance == nil {
+		c.Handler(s, i, instance, configs)
+		return
+	}
+
+	if c.Deferred {
+		// discord waits only 3 seconds for the response
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		})
+		instance.Do(func() {
+			instance.Voice.configs = configs
+		})
+		// the handler uses the loop only to change the state, so the other commands don't wait for its lookups
+		c.Handler(deferredSession{s}, i, instance, configs)
+		return
+	}
+
+	// commands of the same server run one at a time
+	instance.Do(func() {
+		// the config can be reloaded, the player uses the one of the last command
+		instance.Voice.configs = configs
+		c.Handler(s, i, instance, configs)
+	})
+}
+
+// deferredSession answers to an interaction whose response was deferred, by editing it
+type deferredSession struct {
+	discord.Session
+}
+
+func (s deferredSession) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	edit := &discordgo.WebhookEdit{}
+	if resp.Data != nil {
+		if resp.Data.Content != "" {
+			edit.Content = &resp.Data.Content
+		}
+		if resp.Data.Embeds != nil {
+			edit.Embeds = &resp.Data.Embeds
+		}
+		if resp.Data.Components != nil {
+			edit.Components = &resp.Data.Components
+		}
+	}
+	_, err := s.Session.InteractionResponseEdit(interaction, edit, options...)
+	return err
+}
+
+// InteractionUser returns the user that created the interaction, both in servers and in direct messages
+func InteractionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// parseOptions returns the options of the command by name
+func parseOptions(i *discordgo.InteractionCreate) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	options := i.ApplicationCommandData().Options
+
+	optionMap := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
+	for _, opt := range options {
+		optionMap[opt.Name] = opt
+	}
+	return optionMap
+}
+
+var (
+	cooldownLock sync.Mutex
+	cooldowns    = map[string]time.Time{} // command + user -> last use
+)
+
+// checkCooldown returns false if the user used the command too recently, true otherwise.
+// If it returns false, it automatically writes the error back to the user
+func checkCooldown(s discord.Session, i *discordgo.InteractionCreate, c *Command) (res bool) {
+	if c.Cooldown == 0 {
+		return true
+	}
+
+	key := c.Name + "/" + InteractionUser(i).ID
+	now := time.Now()
+
+	cooldownLock.Lock()
+	last, found := cooldowns[key]
+	if found && now.Sub(last) < c.Cooldown {
+		cooldownLock.Unlock()
+		wait := (c.Cooldown - now.Sub(last)).Round(time.Second)
+		SendSimpleMessageResponse(s, i, "Slow down! You can use this command again in "+wait.String(), models.ColorError)
+		return false
+	}
+	cooldowns[key] = now
+	cooldownLock.Unlock()
+
+	return true
+}
+
+// ComponentHandler handles the press of a button. `args` are the parts of the custom id after the prefix
+type ComponentHandler func(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config, args []string)
+
+// components are the handlers of the buttons, by prefix of the custom id.
+// The custom ids are written as `prefix:arg1:arg2`
+var components = map[string]ComponentHandler{
+	"help":   helpPageComponent,
+	"import": importCancelComponent,
+}
+
+// HandleComponent runs the handler of the button pressed
+func HandleComponent(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config) {
+	parts := strings.Split(i.MessageComponentData().CustomID, ":")
+
+	handler := components[parts[0]]
+	if handler == nil {
+		return
+	}
+
+	handler(s, i, instance, configs, parts[1:])
+}
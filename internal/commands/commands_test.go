@@ -0,0 +1,460 @@
+package commands
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/discord/fake"
+	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/youtube"
+)
+
+const (
+	testGuild = "guild"
+	testText  = "text"
+	testVoice = "voice"
+)
+
+// fakeLookup answers the youtube lookups from a list of videos and playlists, without network.
+// Every lookup waits `delay`, to simulate a slow api
+type fakeLookup struct {
+	videos    []youtube.Video
+	playlists map[string][]youtube.Video // id -> videos
+	delay     time.Duration
+}
+
+func (l *fakeLookup) wait(ctx context.Context) error {
+	select {
+	case <-time.After(l.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *fakeLookup) Video(ctx context.Context, id string) (youtube.Video, error) {
+	if err := l.wait(ctx); err != nil {
+		return youtube.Video{}, err
+	}
+	for _, video := range l.videos {
+		if video.ID == id {
+			return video, nil
+		}
+	}
+	return youtube.Video{}, youtube.ErrNotFound
+}
+
+func (l *fakeLookup) Playlist(ctx context.Context, id string) (youtube.Playlist, error) {
+	if err := l.wait(ctx); err != nil {
+		return youtube.Playlist{}, err
+	}
+	videos, ok := l.playlists[id]
+	if !ok {
+		return youtube.Playlist{}, youtube.ErrNotFound
+	}
+	return youtube.Playlist{Videos: videos}, nil
+}
+
+func (l *fakeLookup) PlaylistRange(ctx context.Context, id string, first int, last int, page func(playlist youtube.Playlist, total int) bool) error {
+	return youtube.ErrNotFound
+}
+
+func (l *fakeLookup) Search(ctx context.Context, query string) (string, error) {
+	if err := l.wait(ctx); err != nil {
+		return "", err
+	}
+	for _, video := range l.videos {
+		if strings.Contains(strings.ToLower(video.Title), strings.ToLower(query)) {
+			return video.ID, nil
+		}
+	}
+	return "", youtube.ErrNotFound
+}
+
+var testVideos = []youtube.Video{
+	{ID: "aaaaaaaaaaa", Title: "First song", Author: "Someone", Duration: "3:20"},
+	{ID: "bbbbbbbbbbb", Title: "Second song", Author: "Someone else", Duration: "1:02:03"},
+}
+
+// silence produces `frames` frames of 10 bytes, one every millisecond, so a song lasts long enough to be skipped
+type silence struct {
+	frames int
+}
+
+func (r *silence) Read(p []byte) (int, error) {
+	if r.frames == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(time.Millisecond)
+	r.frames--
+	return copy(p, make([]byte, min(10, len(p)))), nil
+}
+
+func testPipeline(frames int) *audio.Pipeline {
+	return &audio.Pipeline{
+		Source: audio.ReaderSource(func(ctx context.Context, url string) (io.ReadCloser, error) {
+			return io.NopCloser(&silence{frames: frames}), nil
+		}),
+		Encoder:     audio.ChunkEncoder{FrameSize: 10, FrameDuration: 20 * time.Millisecond},
+		BufferBytes: 1024,
+	}
+}
+
+func testConfig() *models.Config {
+	return &models.Config{
+		VoteSkipRatio:      models.DefaultVoteSkipRatio,
+		IdleTimeoutSeconds: models.DefaultIdleTimeoutSeconds,
+		StreamBufferKB:     64,
+		GuildMemoryLimitMB: models.DefaultGuildMemoryLimitMB,
+		MemoryLimitMB:      models.DefaultMemoryLimitMB,
+	}
+}
+
+// newTestServer returns a server with the users in its voice channel, whose songs play silence.
+// The songs are looked up in testVideos
+func newTestServer(t *testing.T, frames int, users ...string) (*fake.Session, *ServerInstance) {
+	s := fake.NewSession("bot")
+	for _, user := range users {
+		s.SetVoiceState(testGuild, user, testVoice)
+	}
+
+	clearCooldowns() // every test starts without cooldowns
+
+	instance := CreateServerInstance(testGuild)
+	instance.Voice.Pipeline = testPipeline(frames)
+	instance.Voice.lookup = &fakeLookup{videos: testVideos}
+
+	t.Cleanup(func() {
+		instance.Do(func() {
+			instance.Voice.skip()
+			instance.Voice.disconnect()
+		})
+	})
+	return s, instance
+}
+
+func clearCooldowns() {
+	cooldownLock.Lock()
+	clear(cooldowns)
+	cooldownLock.Unlock()
+}
+
+var interactionCount atomic.Int64
+
+// slashCommand returns the interaction of `user` using the command
+func slashCommand(name string, user string, options ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{
+		Interaction: &discordgo.Interaction{
+			ID:        "interaction" + strconv.FormatInt(interactionCount.Add(1), 10),
+			Type:      discordgo.InteractionApplicationCommand,
+			GuildID:   testGuild,
+			ChannelID: testText,
+			Member:    &discordgo.Member{User: &discordgo.User{ID: user, Username: user}},
+			Data: discordgo.ApplicationCommandInteractionData{
+				Name:    name,
+				Options: options,
+			},
+		},
+	}
+}
+
+func stringOption(name string, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{
+		Name:  name,
+		Type:  discordgo.ApplicationCommandOptionString,
+		Value: value,
+	}
+}
+
+// runCommand runs the command the way the interaction handler does
+func runCommand(s *fake.Session, instance *ServerInstance, i *discordgo.InteractionCreate, configs *models.Config) {
+	FindCommand(i.ApplicationCommandData().Name).Run(s, i, instance, configs)
+}
+
+// waitFor waits until a message satisfies the condition, and returns its text
+func waitFor(t *testing.T, s *fake.Session, what string, condition func(message fake.Message) bool) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, message := range s.Messages() {
+			if len(message.Embeds) > 0 && condition(message) {
+				return message.Embeds[0].Title + message.Embeds[0].Description
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("no %s after 5s, messages: %v", what, messageTexts(s))
+	return ""
+}
+
+// response waits for the response to the interaction
+func response(t *testing.T, s *fake.Session, i *discordgo.InteractionCreate) string {
+	t.Helper()
+	return waitFor(t, s, "response to "+i.ApplicationCommandData().Name, func(message fake.Message) bool {
+		return message.InteractionID == i.ID
+	})
+}
+
+// nowPlaying waits for the announcement of the song in the text channel
+func nowPlaying(t *testing.T, s *fake.Session, title string) {
+	t.Helper()
+	waitFor(t, s, "now playing "+title, func(message fake.Message) bool {
+		return message.InteractionID == "" && message.Embeds[0].Title == title
+	})
+}
+
+// sending waits until the song playing is sent to the voice connection
+func sending(t *testing.T, s *fake.Session) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if connections := s.Connections(); len(connections) > 0 && connections[len(connections)-1].Frames() > 0 {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("no frames sent after 5s")
+}
+
+func messageTexts(s *fake.Session) (texts []string) {
+	for _, message := range s.Messages() {
+		for _, embed := range message.Embeds {
+			texts = append(texts, embed.Title+embed.Description)
+		}
+	}
+	return texts
+}
+
+func TestPlayCommand(t *testing.T) {
+	configs := testConfig()
+
+	tests := []struct {
+		name     string
+		input    string
+		user     string // empty for a user not in the voice channel
+		response string
+		plays    string // title announced in the text channel, empty if nothing plays
+	}{
+		{"url", "https://www.youtube.com/watch?v=aaaaaaaaaaa", "user", "*First song* added to queue", "First song"},
+		{"short url", "https://youtu.be/bbbbbbbbbbb", "user", "*Second song* added to queue", "Second song"},
+		{"search", "second", "user", "*Second song* added to queue", "Second song"},
+		{"not found", "https://www.youtube.com/watch?v=ccccccccccc", "user", "Couldn't find the", ""},
+		{"search not found", "nothing like this", "user", "Couldn't find the", ""},
+		{"outside voice", "first", "", "You have to join a voice channel", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, instance := newTestServer(t, 200, "user")
+
+			user := test.user
+			if user == "" {
+				user = "outsider"
+			}
+			i := slashCommand("play", user, stringOption("input", test.input))
+			runCommand(s, instance, i, configs)
+
+			if got := response(t, s, i); !strings.Contains(got, test.response) {
+				t.Fatalf("response %q, want %q", got, test.response)
+			}
+			if test.plays == "" {
+				if len(s.Connections()) != 0 {
+					t.Fatal("joined the voice channel without a song")
+				}
+				return
+			}
+
+			nowPlaying(t, s, test.plays)
+			if connections := s.Connections(); len(connections) != 1 || connections[0].ChannelID() != testVoice {
+				t.Fatalf("voice connections %v, want one in %s", connections, testVoice)
+			}
+		})
+	}
+}
+
+func TestSkipCommand(t *testing.T) {
+	configs := testConfig()
+
+	s, instance := newTestServer(t, 5000, "dj")
+
+	for j, video := range testVideos {
+		i := slashCommand("play", "dj"+strconv.Itoa(j), stringOption("input", "https://youtu.be/"+video.ID))
+		s.SetVoiceState(testGuild, "dj"+strconv.Itoa(j), testVoice)
+		runCommand(s, instance, i, configs)
+		response(t, s, i)
+	}
+	nowPlaying(t, s, "First song")
+	sending(t, s)
+
+	skip := slashCommand("skip", "dj")
+	runCommand(s, instance, skip, configs)
+	if got := response(t, s, skip); got != "Song has been skipped" {
+		t.Fatalf("response %q", got)
+	}
+	nowPlaying(t, s, "Second song")
+
+	instance.Do(func() {
+		if queue := instance.Voice.getQueueList(); len(queue) != 1 || queue[0].videoInfo.Title != "Second song" {
+			t.Fatalf("queue after the skip: %v", queue)
+		}
+	})
+
+	// nothing to skip once the queue ends
+	skip = slashCommand("skip", "dj")
+	runCommand(s, instance, skip, configs)
+	response(t, s, skip)
+
+	waitFor(t, s, "end of the queue", func(fake.Message) bool {
+		playing := true
+		instance.Do(func() {
+			playing = instance.Voice.IsPlaying
+		})
+		return !playing
+	})
+
+	skip = slashCommand("skip", "dj")
+	runCommand(s, instance, skip, configs)
+	if got := response(t, s, skip); got != "I'm not playing anything right now" {
+		t.Fatalf("response %q", got)
+	}
+}
+
+func TestQueueCommand(t *testing.T) {
+	configs := testConfig()
+
+	s, instance := newTestServer(t, 5000, "user", "other")
+
+	queue := slashCommand("queue", "user")
+	runCommand(s, instance, queue, configs)
+	if got := response(t, s, queue); got != "I'm not in a voice channel right now" {
+		t.Fatalf("response %q", got)
+	}
+
+	for j, user := range []string{"user", "other"} {
+		i := slashCommand("play", user, stringOption("input", "https://youtu.be/"+testVideos[j].ID))
+		runCommand(s, instance, i, configs)
+		response(t, s, i)
+	}
+	nowPlaying(t, s, "First song")
+
+	queue = slashCommand("queue", "user")
+	runCommand(s, instance, queue, configs)
+	got := response(t, s, queue)
+
+	for _, want := range []string{
+		"0. First song `3:20` *(user)* -> Now playing",
+		"1. Second song `1:02:03` *(other)* -> in ",
+		"**Total:** 2 songs",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("queue %q doesn't contain %q", got, want)
+		}
+	}
+}
+
+// TestConcurrentCommands fires commands at the same server from many goroutines, run it with -race.
+// The slow lookups of /play must not hold the loop of the server
+func TestConcurrentCommands(t *testing.T) {
+	const delay = time.Second
+	configs := testConfig()
+
+	users := []string{"user0", "user1", "user2", "user3"}
+	late := []string{"late0", "late1", "late2"} // play once the first songs are added, without the cooldown
+	s, instance := newTestServer(t, 5000, append(users, late...)...)
+	instance.Voice.lookup = &fakeLookup{videos: testVideos, delay: delay}
+
+	var wg sync.WaitGroup
+	run := func(i *discordgo.InteractionCreate) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runCommand(s, instance, i, configs)
+		}()
+	}
+
+	var plays []*discordgo.InteractionCreate
+	for j, user := range users {
+		i := slashCommand("play", user, stringOption("input", "https://youtu.be/"+testVideos[j%len(testVideos)].ID))
+		plays = append(plays, i)
+		run(i)
+	}
+
+	// the other commands answer while the songs are looked up
+	start := time.Now()
+	queue := slashCommand("queue", "user0")
+	run(queue)
+	response(t, s, queue)
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Fatalf("/queue answered after %s, waiting for the lookups of /play", elapsed)
+	}
+
+	for _, i := range plays {
+		if got := response(t, s, i); !strings.Contains(got, "added to queue") {
+			t.Fatalf("response to /play %q", got)
+		}
+	}
+	// the plays ran concurrently, any of the songs can be the first
+	waitFor(t, s, "now playing", func(message fake.Message) bool {
+		return message.InteractionID == ""
+	})
+
+	var others []*discordgo.InteractionCreate
+	for j, user := range late {
+		for _, name := range []string{"pause", "resume", "queue", "skip"} {
+			i := slashCommand(name, users[j])
+			others = append(others, i)
+			run(i)
+		}
+		i := slashCommand("play", user, stringOption("input", "second"))
+		others = append(others, i)
+		run(i)
+	}
+	wg.Wait()
+
+	for _, i := range others {
+		response(t, s, i)
+	}
+
+	if connections := s.Connections(); len(connections) != 1 {
+		t.Fatalf("%d voice connections, want 1", len(connections))
+	}
+	instance.Do(func() {
+		v := instance.Voice
+		// the player may have taken the next song without dequeuing it yet
+		if len(v.QueueList) < len(v.Queue) || len(v.QueueList) > len(v.Queue)+1 {
+			t.Fatalf("%d songs in the queue list, %d waiting to play", len(v.QueueList), len(v.Queue))
+		}
+	})
+}
+
+func TestImportCancelComponent(t *testing.T) {
+	s := fake.NewSession("bot")
+
+	// the button can be pressed in a direct message, where there's no server
+	i := &discordgo.InteractionCreate{
+		Interaction: &discordgo.Interaction{
+			ID:   "button",
+			Type: discordgo.InteractionMessageComponent,
+			User: &discordgo.User{ID: "user"},
+			Data: discordgo.MessageComponentInteractionData{CustomID: "import:interaction"},
+		},
+	}
+	HandleComponent(s, i, nil, testConfig())
+
+	got := waitFor(t, s, "response to the button", func(message fake.Message) bool {
+		return message.InteractionID == i.ID
+	})
+	if got != "This playlist is not being added anymore" {
+		t.Fatalf("response %q", got)
+	}
+}
@@ -0,0 +1,239 @@
+package commands
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// Commands are all the slash commands of the bot.
+// They are set in init, since some handlers need to read the list
+var Commands []*Command
+
+// minimum values of the integer options
+var (
+	minZero = 0.0
+	minOne  = 1.0
+)
+
+func init() {
+	Commands = []*Command{
+		{
+			Name:        "ping",
+			Description: "pong",
+			Handler:     PingCommand,
+		},
+		{
+			Name:        "help",
+			Description: "Lists the commands, or shows the details of one",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "command",
+					Description: "Name of the command",
+					Required:    false,
+				},
+			},
+			Examples: []string{"/help", "/help play"},
+			Handler:  HelpCommand,
+		},
+		{
+			Name:        "play",
+			Description: "Adds a song or playlist to the queue. Supports youtube links, spotify links and youtube search",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "input",
+					Description: "Url of the song or search input",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "skip-playlist",
+					Description: "Number of songs to skip in case the input is a playlist",
+					Required:    false,
+					MinValue:    &minZero,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "start",
+					Description: "Position of the first song to add in case the input is a playlist, from 1",
+					Required:    false,
+					MinValue:    &minOne,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "end",
+					Description: "Position of the last song to add in case the input is a playlist",
+					Required:    false,
+					MinValue:    &minOne,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "limit",
+					Description: "Maximum number of songs to add in case the input is a playlist",
+					Required:    false,
+					MinValue:    &minOne,
+				},
+			},
+			Examples: []string{
+				"/play input:https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+				"/play input:never gonna give you up",
+				"/play input:https://www.youtube.com/playlist?list=... skip-playlist:10",
+				"/play input:https://www.youtube.com/playlist?list=... start:20 end:40",
+				"/play input:https://www.youtube.com/playlist?list=... limit:15",
+			},
+			Cooldown:  2 * time.Second,
+			GuildOnly: true,
+			Deferred:  true,
+			Handler:   PlayCommand,
+		},
+		{
+			Name:        "skip",
+			Description: "Skips the current song",
+			Permission:  models.PermissionDJ, // needed to skip without a vote
+			Votable:     true,
+			GuildOnly:   true,
+			Handler:     SkipSong,
+		},
+		{
+			Name:        "pause",
+			Description: "Pause the current song",
+			GuildOnly:   true,
+			Handler:     PauseSong,
+		},
+		{
+			Name:        "resume",
+			Description: "Resume the current song",
+			GuildOnly:   true,
+			Handler:     ResumeSong,
+		},
+		{
+			Name:        "disconnect",
+			Description: "Disconnects the bot from the voice channel",
+			Permission:  models.PermissionDJ,
+			GuildOnly:   true,
+			Handler:     Disconnect,
+		},
+		{
+			Name:        "clear",
+			Description: "Clear the queue",
+			Permission:  models.PermissionDJ,
+			GuildOnly:   true,
+			Handler:     ClearQueue,
+		},
+		{
+			Name:        "queue",
+			Description: "Lists the songs in the queue",
+			GuildOnly:   true,
+			Handler:     GetQueue,
+		},
+		{
+			Name:        "autoplay",
+			Description: "Keeps playing related songs when the queue ends",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "enabled",
+					Description: "Enable or disable autoplay. Toggles it if not set",
+					Required:    false,
+				},
+			},
+			Examples:   []string{"/autoplay", "/autoplay enabled:false"},
+			Permission: models.PermissionDJ,
+			GuildOnly:  true,
+			Handler:    AutoplayCommand,
+		},
+		{
+			Name:        "fairqueue",
+			Description: "Rotates the queue between the users who requested the songs",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "enabled",
+					Description: "Enable or disable the fair queue. Toggles it if not set",
+					Required:    false,
+				},
+			},
+			Examples:   []string{"/fairqueue", "/fairqueue enabled:true"},
+			Permission: models.PermissionDJ,
+			GuildOnly:  true,
+			Handler:    FairQueueCommand,
+		},
+		{
+			Name:        "profile",
+			Description: "Chooses how the songs are encoded, or shows the current profile",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "name",
+					Description: "Name of the profile, ex. default, music, low, or passthrough to not encode opus again",
+					Required:    false,
+				},
+			},
+			Examples:   []string{"/profile", "/profile name:music"},
+			Permission: models.PermissionDJ,
+			GuildOnly:  true,
+			Handler:    ProfileCommand,
+		},
+		{
+			Name:        "idle",
+			Description: "Sets how long the bot stays in the voice channel idle or paused, or shows the current state",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "minutes",
+					Description: "Minutes before leaving, 0 for the default of the bot",
+					Required:    false,
+					MinValue:    &minZero,
+					MaxValue:    float64(models.MaxIdleTimeoutMinutes),
+				},
+			},
+			Examples:   []string{"/idle", "/idle minutes:30"},
+			Permission: models.PermissionDJ,
+			GuildOnly:  true,
+			Handler:    IdleCommand,
+		},
+		{
+			Name:        "stats",
+			Description: "Shows the memory used by the bot and the youtube quota spent today",
+			Permission:  models.PermissionAdmin,
+			GuildOnly:   true,
+			Handler:     StatsCommand,
+		},
+		{
+			Name:        "cache",
+			Description: "Manages the songs saved on disk",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "stats",
+					Description: "Shows the size of the cache and how often it's used",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "clear",
+					Description: "Deletes all the songs of the cache",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionSubCommand,
+					Name:        "warmup",
+					Description: "Saves the songs of a playlist in the cache",
+					Options: []*discordgo.ApplicationCommandOption{
+						{
+							Type:        discordgo.ApplicationCommandOptionString,
+							Name:        "playlist",
+							Description: "Url of the youtube playlist",
+							Required:    true,
+						},
+					},
+				},
+			},
+			Examples:   []string{"/cache stats", "/cache clear", "/cache warmup playlist:https://www.youtube.com/playlist?list=..."},
+			Permission: models.PermissionAdmin,
+			GuildOnly:  true,
+			Handler:    CacheCommand,
+		},
+	}
+}
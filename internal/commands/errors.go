@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"errors"
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/youtube"
+)
+
+// errUnsupported is returned for the inputs the bot can't play
+var errUnsupported = errors.New("unsupported input")
+
+// errorMessage returns the message shown to the user for the error.
+// `what` is what was being read, ex. "video" or "playlist"
+func errorMessage(err error, what string) string {
+	var unavailable *youtube.UnavailableError
+
+	switch {
+	case errors.Is(err, errUnsupported):
+		return "I can't play that. Use a youtube video or playlist, a spotify song, or search something"
+	case errors.As(err, &unavailable) && unavailable.Reason != "private":
+		return "This " + what + " can't be played (" + unavailable.Reason + ")"
+	case errors.Is(err, youtube.ErrPrivate):
+		return "This " + what + " is private"
+	case errors.Is(err, youtube.ErrNotFound):
+		return "Couldn't find the " + what + ", check if the url is correct"
+	case errors.Is(err, youtube.ErrQuota):
+		return "The youtube quota of the day is over and the " + what + " couldn't be read in another way, try again later"
+	case errors.Is(err, youtube.ErrNetwork):
+		return "Couldn't connect to read the " + what + ", try again in a moment"
+	default:
+		return "Something went wrong reading the " + what + ", try again later"
+	}
+}
+
+// respondError logs the error and writes its message back to the user
+func respondError(s discord.Responder, i *discordgo.InteractionCreate, err error, what string) {
+	log.Println("ERR: internal/commands/errors.go: Error reading the "+what+" - ", err)
+	SendSimpleMessageResponse(s, i, errorMessage(err, what), models.ColorError)
+}
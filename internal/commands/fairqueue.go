@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"sort"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// FairQueueCommand enables or disables the fair queue mode.
+// Without the `enabled` option it toggles the current state
+func FairQueueCommand(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, _ *models.Config) {
+	enabled := !instance.Voice.FairQueue
+	if opt := parseOptions(i)["enabled"]; opt != nil {
+		enabled = opt.BoolValue()
+	}
+
+	instance.Voice.FairQueue = enabled
+
+	if enabled {
+		instance.Voice.reorderQueue()
+		SendSimpleMessageResponse(s, i, "Fair queue enabled. Songs will rotate between the users who requested them", models.ColorDefault)
+	} else {
+		SendSimpleMessageResponse(s, i, "Fair queue disabled. Songs will be played in the order they were added", models.ColorDefault)
+	}
+}
+
+// reorderQueue sorts the songs that are still waiting in the queue in round-robin order between requesters.
+// The songs already taken by the player are left untouched
+func (v *VoiceInstance) reorderQueue() {
+	if v.Queue == nil {
+		return
+	}
+
+	waiting := v.drainQueue()
+
+	taken := v.QueueList[:len(v.QueueList)-len(waiting)]
+	waiting = fairOrder(taken, waiting)
+
+	for _, song := range waiting {
+		v.Queue <- song
+	}
+	v.QueueList = append(taken, waiting...)
+	v.refreshPrefetch()
+}
+
+// fairOrder returns the `waiting` songs sorted in rounds: every requester gets one song per round,
+// in the order they first appear in the queue. The songs in `taken` count towards the first round
+func fairOrder(taken []Song, waiting []Song) (res []Song) {
+	count := map[string]int{}
+	for _, song := range taken {
+		count[song.requesterId]++
+	}
+
+	type entry struct {
+		song  Song
+		round int
+		rank  int
+	}
+
+	rank := map[string]int{}
+	entries := make([]entry, 0, len(waiting))
+	for _, song := range waiting {
+		if _, ok := rank[song.requesterId]; !ok {
+			rank[song.requesterId] = len(rank)
+		}
+		entries = append(entries, entry{song, count[song.requesterId], rank[song.requesterId]})
+		count[song.requesterId]++
+	}
+
+	sort.SliceStable(entries, func(a, b int) bool {
+		if entries[a].round != entries[b].round {
+			return entries[a].round < entries[b].round
+		}
+		return entries[a].rank < entries[b].rank
+	})
+
+	res = make([]Song, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, e.song)
+	}
+	return res
+}
@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+)
+
+// requests returns a song for each requester, named after the requester and its number of songs, ex. "A1 A2 B1"
+func requests(requesters ...string) (list []Song) {
+	count := map[string]int{}
+	for _, requester := range requesters {
+		count[requester]++
+		list = append(list, Song{
+			videoInfo:   VideoInfo{ID: requester + string(rune('0'+count[requester]))},
+			requesterId: requester,
+		})
+	}
+	return list
+}
+
+func songIds(list []Song) string {
+	ids := make([]string, 0, len(list))
+	for _, song := range list {
+		ids = append(ids, song.videoInfo.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestFairOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		taken   []Song
+		waiting []Song
+		want    string
+	}{
+		{"empty", nil, nil, ""},
+		{"single requester", nil, requests("A", "A", "A"), "A1 A2 A3"},
+		{"one requester first", nil, requests("A", "A", "A", "B", "C"), "A1 B1 C1 A2 A3"},
+		{"order of the first song", nil, requests("B", "A", "A", "B"), "B1 A1 B2 A2"},
+		{"taken counts in the first round", requests("A"), requests("A", "A", "A", "B")[1:], "B1 A2 A3"},
+		{"taken by everyone", requests("A", "B"), requests("A", "B", "B", "A")[2:], "B2 A2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := songIds(fairOrder(test.taken, test.waiting)); got != test.want {
+				t.Fatalf("order %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReorderQueue(t *testing.T) {
+	tests := []struct {
+		name  string
+		queue []Song
+		taken int // songs of the queue already taken by the player
+		want  string
+	}{
+		{"empty", nil, 0, ""},
+		{"single requester", requests("A", "A", "A"), 0, "A1 A2 A3"},
+		{"one requester first", requests("A", "A", "A", "B", "C"), 0, "A1 B1 C1 A2 A3"},
+		{"song playing", requests("A", "A", "A", "B", "C"), 1, "A1 B1 C1 A2 A3"},
+		{"every song taken", requests("A", "A", "B"), 3, "A1 A2 B1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := &VoiceInstance{
+				Queue:     make(chan Song, 10),
+				QueueList: append([]Song(nil), test.queue...),
+			}
+			for _, song := range test.queue[test.taken:] {
+				v.Queue <- song
+			}
+
+			v.reorderQueue()
+
+			if got := songIds(v.QueueList); got != test.want {
+				t.Fatalf("queue list %q, want %q", got, test.want)
+			}
+
+			// the songs still in the channel follow the queue list
+			waiting := v.drainQueue()
+			if got, want := songIds(waiting), songIds(v.QueueList[test.taken:]); got != want {
+				t.Fatalf("songs waiting %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReorderQueueWithoutSession(t *testing.T) {
+	v := &VoiceInstance{QueueList: requests("A", "A", "B")}
+	v.reorderQueue()
+
+	if got := songIds(v.QueueList); got != "A1 A2 B1" {
+		t.Fatalf("queue list %q, changed without a session", got)
+	}
+}
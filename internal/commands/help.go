@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// HelpCommand lists the commands, or shows the details of one if the `command` option is set
+func HelpCommand(s discord.Session, i *discordgo.InteractionCreate, _ *ServerInstance, configs *models.Config) {
+	opt := parseOptions(i)["command"]
+	if opt == nil {
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: helpPage(0),
+		})
+		return
+	}
+
+	name := strings.TrimPrefix(strings.TrimSpace(opt.StringValue()), "/")
+	command := FindCommand(name)
+	if command == nil {
+		SendSimpleMessageResponse(s, i, "There's no command called `"+name+"`. Use `/help` to list them", models.ColorError)
+		return
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{helpDetail(command, configs)},
+		},
+	})
+}
+
+// helpPageComponent changes the page of the list of commands, when a button of the list is pressed
+func helpPageComponent(s discord.Session, i *discordgo.InteractionCreate, _ *ServerInstance, _ *models.Config, args []string) {
+	page := 0
+	if len(args) > 0 {
+		page, _ = strconv.Atoi(args[0])
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: helpPage(page),
+	})
+}
+
+// helpPage returns the page of the list of commands, with the buttons to move between pages
+func helpPage(page int) *discordgo.InteractionResponseData {
+	pages := (len(Commands) + models.HelpPageSize - 1) / models.HelpPageSize
+	page = max(0, min(page, pages-1))
+
+	embed := &discordgo.MessageEmbed{
+		Title:       "Commands",
+		Description: "Use `/help <command>` to see the details of a command",
+		Color:       models.ColorDefault,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: "Page " + strconv.Itoa(page+1) + "/" + strconv.Itoa(pages),
+		},
+	}
+
+	end := min((page+1)*models.HelpPageSize, len(Commands))
+	for _, c := range Commands[page*models.HelpPageSize : end] {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "/" + c.Name,
+			Value: c.Description,
+		})
+	}
+
+	data := &discordgo.InteractionResponseData{
+		Embeds: []*discordgo.MessageEmbed{embed},
+	}
+
+	if pages > 1 {
+		data.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{
+				Components: []discordgo.MessageComponent{
+					discordgo.Button{
+						Label:    "Previous",
+						Style:    discordgo.SecondaryButton,
+						CustomID: "help:" + strconv.Itoa(page-1),
+						Disabled: page == 0,
+					},
+					discordgo.Button{
+						Label:    "Next",
+						Style:    discordgo.SecondaryButton,
+						CustomID: "help:" + strconv.Itoa(page+1),
+						Disabled: page == pages-1,
+					},
+				},
+			},
+		}
+	}
+
+	return data
+}
+
+// helpDetail returns the description, options, examples and requirements of the command
+func helpDetail(c *Command, configs *models.Config) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:       "/" + c.Name,
+		Description: c.Description,
+		Color:       models.ColorDefault,
+	}
+
+	if len(c.Options) > 0 {
+		options := ""
+		for _, o := range c.Options {
+			if o.Type == discordgo.ApplicationCommandOptionSubCommand {
+				options += "`" + o.Name + "` (subcommand): " + o.Description + "\n"
+				continue
+			}
+			required := "optional"
+			if o.Required {
+				required = "required"
+			}
+			options += "`" + o.Name + "` (" + strings.ToLower(o.Type.String()) + ", " + required + "): " + o.Description + "\n"
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Options", Value: options})
+	}
+
+	if len(c.Examples) > 0 {
+		examples := ""
+		for _, e := range c.Examples {
+			examples += "`" + e + "`\n"
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Examples", Value: examples})
+	}
+
+	permission := commandPermission(c, configs).String()
+	if c.Votable {
+		permission += " (the others can vote)"
+	}
+	embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Permission", Value: permission, Inline: true})
+
+	if c.Cooldown > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Cooldown", Value: c.Cooldown.String(), Inline: true})
+	}
+
+	if c.GuildOnly {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: "Where", Value: "Servers only", Inline: true})
+	}
+
+	return embed
+}
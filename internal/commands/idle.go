@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// IdleState is what the bot is doing in the voice channel, as seen by the idle manager
+type IdleState int
+
+const (
+	IdleOff           IdleState = iota // not in a voice channel
+	IdleWaiting                        // in the channel with nothing to play, leaves after the timeout
+	IdlePlaying                        // playing a song
+	IdlePaused                         // the song is paused, leaves after the timeout
+	IdlePausedTooLong                  // the song was paused longer than the timeout, the bot is leaving
+)
+
+func (state IdleState) String() string {
+	switch state {
+	case IdleWaiting:
+		return "idle"
+	case IdlePlaying:
+		return "playing"
+	case IdlePaused:
+		return "paused"
+	case IdlePausedTooLong:
+		return "paused for too long"
+	}
+	return "not connected"
+}
+
+// idleManager makes the bot leave when it stays idle, or paused, longer than the timeout of the server.
+// Every countdown has its own context, cancelled when the state changes: its goroutine always ends,
+// and a countdown that expires while the state is changing is ignored.
+// It's used only on the loop of the server
+type idleManager struct {
+	state  IdleState
+	ctx    context.Context // of the countdown running, nil if none
+	cancel context.CancelFunc
+
+	expired func(state IdleState) // runs on the loop of the server when a countdown expires
+	do      func(action func())   // runs the action on the loop of the server
+}
+
+// set changes the state and starts its countdown. Nothing happens if the state doesn't change
+func (m *idleManager) set(state IdleState, timeout time.Duration) {
+	if state == m.state {
+		return
+	}
+	m.state = state
+	m.restart(timeout)
+}
+
+// restart starts the countdown of the current state again, ex. when the timeout changed.
+// Only idle and paused have a countdown, and a timeout of 0 disables it
+func (m *idleManager) restart(timeout time.Duration) {
+	m.stop()
+
+	if (m.state != IdleWaiting && m.state != IdlePaused) || timeout <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	m.ctx = ctx
+	m.cancel = cancel
+	go m.wait(ctx)
+}
+
+func (m *idleManager) stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.ctx = nil
+	m.cancel = nil
+}
+
+func (m *idleManager) wait(ctx context.Context) {
+	<-ctx.Done()
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) { // the state changed
+		return
+	}
+
+	m.do(func() {
+		if m.ctx != ctx { // the state changed while the countdown was expiring
+			return
+		}
+		m.stop()
+
+		if m.state == IdlePaused {
+			m.state = IdlePausedTooLong
+		}
+		if m.expired != nil {
+			m.expired(m.state)
+		}
+	})
+}
+
+// remaining returns the time left before the bot leaves, false if it's not counting down
+func (m *idleManager) remaining() (time.Duration, bool) {
+	if m.ctx == nil {
+		return 0, false
+	}
+	deadline, _ := m.ctx.Deadline()
+	return max(0, time.Until(deadline)), true
+}
+
+// idleTimeout returns the timeout chosen by the server, or the one of the config
+func (v *VoiceInstance) idleTimeout(configs *models.Config) time.Duration {
+	if v.IdleTimeoutMinutes > 0 {
+		return time.Duration(v.IdleTimeoutMinutes) * time.Minute
+	}
+	if configs == nil {
+		return time.Duration(models.DefaultIdleTimeoutSeconds) * time.Second
+	}
+	return time.Duration(configs.IdleTimeoutSeconds) * time.Second
+}
+
+// setIdle changes the state of the idle manager, with the timeout of the server
+func (v *VoiceInstance) setIdle(state IdleState) {
+	v.idle.set(state, v.idleTimeout(v.configs))
+}
+
+// onIdleExpired leaves the voice channel when the countdown of the idle manager expires
+func (v *VoiceInstance) onIdleExpired(s discord.Messenger, state IdleState) {
+	message := "Disconnected for inactivity"
+	if state == IdlePausedTooLong {
+		message = "Disconnected because the song was paused for too long"
+	}
+
+	log.Println("Bot disconnected while " + state.String())
+	channel := v.TextChannelId
+	v.skip()
+	v.disconnect()
+	SendSimpleMessageToChannel(s, channel, message, models.ColorDefault)
+}
+
+// IdleCommand sets how long the bot stays in the voice channel idle or paused.
+// Without the `minutes` option it shows the current timeout and what the bot is doing
+func IdleCommand(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config) {
+	v := instance.Voice
+
+	opt := parseOptions(i)["minutes"]
+	if opt == nil {
+		message := "State: **" + v.idle.state.String() + "**"
+		if remaining, ok := v.idle.remaining(); ok {
+			message += ", leaving in " + formatDuration(remaining)
+		}
+		SendSimpleMessageResponse(s, i, message+"\n"+idleTimeoutMessage(v, configs), models.ColorDefault)
+		return
+	}
+
+	v.IdleTimeoutMinutes = int(opt.IntValue())
+	v.idle.restart(v.idleTimeout(configs))
+
+	SendSimpleMessageResponse(s, i, idleTimeoutMessage(v, configs), models.ColorDefault)
+}
+
+func idleTimeoutMessage(v *VoiceInstance, configs *models.Config) string {
+	timeout := v.idleTimeout(configs)
+
+	message := "Leaving after " + formatDuration(timeout) + " idle or paused"
+	if timeout <= 0 {
+		message = "Never leaving for inactivity"
+	}
+	if v.IdleTimeoutMinutes == 0 {
+		message += " (default of the bot)"
+	} else {
+		message += " (" + strconv.Itoa(v.IdleTimeoutMinutes) + " minutes set for this server)"
+	}
+	return message
+}
@@ -0,0 +1,195 @@
+package commands
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// idleStep changes the state of the idle manager `at` from the start of the test
+type idleStep struct {
+	at      time.Duration
+	state   IdleState
+	restart bool // restarts the countdown instead of setting the state
+}
+
+func TestIdleManager(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		steps   []idleStep
+		expired []IdleState   // states expired, in order
+		after   time.Duration // the first expires after, from the start
+		before  time.Duration // the first expires before, 0 to not check
+	}{
+		{
+			name:    "playing",
+			timeout: timeout,
+			steps:   []idleStep{{state: IdlePlaying}},
+		},
+		{
+			name:    "idle",
+			timeout: timeout,
+			steps:   []idleStep{{state: IdleWaiting}},
+			expired: []IdleState{IdleWaiting},
+			after:   timeout,
+		},
+		{
+			name:    "paused",
+			timeout: timeout,
+			steps:   []idleStep{{state: IdlePlaying}, {at: 20 * time.Millisecond, state: IdlePaused}},
+			expired: []IdleState{IdlePausedTooLong},
+			after:   20*time.Millisecond + timeout,
+		},
+		{
+			name:    "resumed before the timeout",
+			timeout: timeout,
+			steps:   []idleStep{{state: IdlePaused}, {at: 50 * time.Millisecond, state: IdlePlaying}},
+		},
+		{
+			name:    "song added while idle",
+			timeout: timeout,
+			steps:   []idleStep{{state: IdleWaiting}, {at: 50 * time.Millisecond, state: IdlePlaying}, {at: 60 * time.Millisecond, state: IdleWaiting}},
+			expired: []IdleState{IdleWaiting},
+			after:   60*time.Millisecond + timeout,
+		},
+		{
+			name:    "reset by a new timeout",
+			timeout: timeout,
+			steps:   []idleStep{{state: IdleWaiting}, {at: 60 * time.Millisecond, restart: true}},
+			expired: []IdleState{IdleWaiting},
+			after:   60*time.Millisecond + timeout,
+		},
+		{
+			name:    "same state doesn't reset",
+			timeout: timeout,
+			steps:   []idleStep{{state: IdleWaiting}, {at: 60 * time.Millisecond, state: IdleWaiting}},
+			expired: []IdleState{IdleWaiting},
+			after:   timeout,
+			before:  60*time.Millisecond + timeout,
+		},
+		{
+			name:    "disabled",
+			timeout: 0,
+			steps:   []idleStep{{state: IdleWaiting}},
+		},
+		{
+			name:    "disconnected",
+			timeout: timeout,
+			steps:   []idleStep{{state: IdlePaused}, {at: 20 * time.Millisecond, state: IdleOff}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			// the lock stands for the loop of the server
+			var lock sync.Mutex
+			var expired []IdleState
+			var first time.Duration
+			start := time.Now()
+
+			m := &idleManager{
+				do: func(action func()) {
+					lock.Lock()
+					defer lock.Unlock()
+					action()
+				},
+			}
+			m.expired = func(state IdleState) {
+				if len(expired) == 0 {
+					first = time.Since(start)
+				}
+				expired = append(expired, state)
+				m.state = IdleOff // like onIdleExpired, that disconnects
+			}
+
+			for _, step := range test.steps {
+				time.Sleep(time.Until(start.Add(step.at)))
+				m.do(func() {
+					if step.restart {
+						m.restart(test.timeout)
+					} else {
+						m.set(step.state, test.timeout)
+					}
+				})
+			}
+
+			time.Sleep(time.Until(start.Add(3 * timeout)))
+
+			m.do(func() {
+				defer m.stop()
+
+				if len(expired) != len(test.expired) {
+					t.Fatalf("expired %v, want %v", expired, test.expired)
+				}
+				for j := range expired {
+					if expired[j] != test.expired[j] {
+						t.Fatalf("expired %v, want %v", expired, test.expired)
+					}
+				}
+				if len(expired) == 0 {
+					return
+				}
+				if first < test.after {
+					t.Fatalf("expired after %s, want after %s", first, test.after)
+				}
+				if test.before > 0 && first >= test.before {
+					t.Fatalf("expired after %s, want before %s", first, test.before)
+				}
+			})
+		})
+	}
+}
+
+// TestIdleEmptyChannel checks that the song paused because everyone left counts as paused,
+// and that it plays again when somebody comes back
+func TestIdleEmptyChannel(t *testing.T) {
+	configs := testConfig()
+	configs.EmptyChannelTimeoutSeconds = 60
+
+	s, instance := newTestServer(t, 5000, "user")
+
+	i := slashCommand("play", "user", stringOption("input", "https://youtu.be/"+testVideos[0].ID))
+	runCommand(s, instance, i, configs)
+	response(t, s, i)
+	nowPlaying(t, s, "First song")
+	sending(t, s) // the song can be paused
+
+	voiceUpdate := func(channel string, before string) {
+		s.SetVoiceState(testGuild, "user", channel)
+		instance.Do(func() {
+			HandleVoiceStateUpdate(s, &discordgo.VoiceStateUpdate{
+				VoiceState:   &discordgo.VoiceState{GuildID: testGuild, UserID: "user", ChannelID: channel},
+				BeforeUpdate: &discordgo.VoiceState{GuildID: testGuild, UserID: "user", ChannelID: before},
+			}, instance, configs)
+		})
+	}
+
+	state := func() (state IdleState, counting bool) {
+		instance.Do(func() {
+			state = instance.Voice.idle.state
+			_, counting = instance.Voice.idle.remaining()
+		})
+		return state, counting
+	}
+
+	if got, counting := state(); got != IdlePlaying || counting {
+		t.Fatalf("state %s while playing, counting down %v", got, counting)
+	}
+
+	voiceUpdate("", testVoice)
+	if got, counting := state(); got != IdlePaused || !counting {
+		t.Fatalf("state %s with the channel empty, counting down %v", got, counting)
+	}
+
+	voiceUpdate(testVoice, "")
+	if got, counting := state(); got != IdlePlaying || counting {
+		t.Fatalf("state %s after coming back, counting down %v", got, counting)
+	}
+}
@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// commandPermission returns the level needed to use the command.
+// The level of the definition can be overridden with the COMMAND_PERMISSIONS config,
+// written as `command=level` pairs separated by commas (ex. "clear=everyone,skip=dj")
+func commandPermission(command *Command, configs *models.Config) (level models.PermissionLevel) {
+	level = command.Permission
+
+	for _, pair := range strings.Split(configs.CommandPermissions, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || strings.TrimSpace(name) != command.Name {
+			continue
+		}
+		if l, ok := models.PermissionLevels[strings.ToLower(strings.TrimSpace(value))]; ok {
+			level = l
+		}
+	}
+
+	return level
+}
+
+// ValidatePermissions checks that the COMMAND_PERMISSIONS config only names existing commands and levels
+func ValidatePermissions(configs *models.Config) (err error) {
+	var errs []error
+
+	for _, pair := range strings.Split(configs.CommandPermissions, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(pair, "=")
+		if !found {
+			errs = append(errs, errors.New("COMMAND_PERMISSIONS: \""+pair+"\" is not in the form command=level"))
+			continue
+		}
+		if FindCommand(strings.TrimSpace(name)) == nil {
+			errs = append(errs, errors.New("COMMAND_PERMISSIONS: unknown command \""+strings.TrimSpace(name)+"\""))
+		}
+		if _, ok := models.PermissionLevels[strings.ToLower(strings.TrimSpace(value))]; !ok {
+			errs = append(errs, errors.New("COMMAND_PERMISSIONS: unknown level \""+strings.TrimSpace(value)+"\""))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// memberPermission returns the level of the user that created the interaction
+func memberPermission(s discord.Session, i *discordgo.InteractionCreate, configs *models.Config) (level models.PermissionLevel) {
+	if i.Member == nil { // direct message
+		return models.PermissionEveryone
+	}
+
+	if i.Member.Permissions&(discordgo.PermissionAdministrator|discordgo.PermissionManageServer) != 0 {
+		return models.PermissionAdmin
+	}
+
+	// without a dj role everyone can control the player
+	if configs.DJRole == "" {
+		return models.PermissionDJ
+	}
+
+	for _, roleId := range i.Member.Roles {
+		if roleId == configs.DJRole {
+			return models.PermissionDJ
+		}
+		if strings.EqualFold(s.RoleName(i.GuildID, roleId), configs.DJRole) {
+			return models.PermissionDJ
+		}
+	}
+
+	return models.PermissionEveryone
+}
+
+// hasCommandPermission returns true if the user that created the interaction has the level needed by the command
+func hasCommandPermission(s discord.Session, i *discordgo.InteractionCreate, command *Command, configs *models.Config) (res bool) {
+	return memberPermission(s, i, configs) >= commandPermission(command, configs)
+}
+
+// CheckCommandPermission returns false if the user can't use the command, true otherwise.
+// If it returns false, it automatically writes the error back to the user
+func CheckCommandPermission(s discord.Session, i *discordgo.InteractionCreate, command *Command, configs *models.Config) (res bool) {
+	needed := commandPermission(command, configs)
+	if memberPermission(s, i, configs) >= needed {
+		return true
+	}
+
+	if needed == models.PermissionAdmin {
+		SendSimpleMessageResponse(s, i, "Only admins can use this command", models.ColorError)
+	} else {
+		SendSimpleMessageResponse(s, i, "You need the DJ role to use this command", models.ColorError)
+	}
+	return false
+}
+
+// listeners returns the users, bots excluded, in the voice channel
+func listeners(s discord.Session, guildId string, channelId string) (users []string) {
+	for _, userId := range s.VoiceChannelUsers(guildId, channelId) {
+		if userId == s.BotUserID() || s.IsBot(guildId, userId) {
+			continue
+		}
+		users = append(users, userId)
+	}
+
+	return users
+}
+
+// countListeners returns the number of users, bots excluded, in the voice channel
+func countListeners(s discord.Session, guildId string, channelId string) (count int) {
+	return len(listeners(s, guildId, channelId))
+}
+
+// voteSkip registers the vote of the user for skipping the current song.
+// Only the votes of the users still in the voice channel count.
+// Returns true when enough listeners voted
+func (v *VoiceInstance) voteSkip(s discord.Session, i *discordgo.InteractionCreate, configs *models.Config) (res bool, votes int, needed int) {
+	v.SkipVotes[i.Member.User.ID] = true
+
+	users := listeners(s, i.GuildID, v.ChannelId)
+	for _, userId := range users {
+		if v.SkipVotes[userId] {
+			votes++
+		}
+	}
+
+	needed = int(math.Ceil(float64(len(users)) * configs.VoteSkipRatio))
+	if needed < 1 {
+		needed = 1
+	}
+
+	return votes >= needed, votes, needed
+}
+
+// voteSkipMessage returns the message shown after a vote that didn't skip the song
+func voteSkipMessage(votes int, needed int) string {
+	return "Vote registered: " + strconv.Itoa(votes) + "/" + strconv.Itoa(needed) + " votes needed to skip"
+}
@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+func TestCommandPermission(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions string
+		command     string
+		want        models.PermissionLevel
+	}{
+		{"definition", "", "clear", models.PermissionDJ},
+		{"lowered", "clear=everyone", "clear", models.PermissionEveryone},
+		{"raised", "play=admin", "play", models.PermissionAdmin},
+		{"spaces and case", " skip = Everyone , clear=admin", "skip", models.PermissionEveryone},
+		{"other command", "clear=everyone", "skip", models.PermissionDJ},
+		{"unknown level ignored", "clear=nobody", "clear", models.PermissionDJ},
+		{"last override wins", "clear=everyone,clear=admin", "clear", models.PermissionAdmin},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configs := testConfig()
+			configs.CommandPermissions = test.permissions
+
+			if got := commandPermission(FindCommand(test.command), configs); got != test.want {
+				t.Fatalf("level %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	tests := []struct {
+		permissions string
+		errors      []string
+	}{
+		{"", nil},
+		{"clear=everyone, skip=dj,", nil},
+		{"clear", []string{`COMMAND_PERMISSIONS: "clear" is not in the form command=level`}},
+		{"missing=dj", []string{`COMMAND_PERMISSIONS: unknown command "missing"`}},
+		{"clear=nobody", []string{`COMMAND_PERMISSIONS: unknown level "nobody"`}},
+		{"missing=nobody,skip", []string{
+			`COMMAND_PERMISSIONS: unknown command "missing"`,
+			`COMMAND_PERMISSIONS: unknown level "nobody"`,
+			`COMMAND_PERMISSIONS: "skip" is not in the form command=level`,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.permissions, func(t *testing.T) {
+			configs := testConfig()
+			configs.CommandPermissions = test.permissions
+
+			var got []string
+			if err := ValidatePermissions(configs); err != nil {
+				got = strings.Split(err.Error(), "\n")
+			}
+			if strings.Join(got, "\n") != strings.Join(test.errors, "\n") {
+				t.Fatalf("errors %q, want %q", got, test.errors)
+			}
+		})
+	}
+}
+
+// TestVoteSkip skips the song of `a` by vote: with 3 listeners 2 votes are needed,
+// and the vote of a user that left the channel doesn't count
+func TestVoteSkip(t *testing.T) {
+	configs := testConfig()
+	configs.DJRole = "dj"
+
+	s, instance := newTestServer(t, 5000, "a", "b", "c", "d")
+	i := slashCommand("play", "a", stringOption("input", "https://youtu.be/"+testVideos[0].ID))
+	runCommand(s, instance, i, configs)
+	response(t, s, i)
+	nowPlaying(t, s, "First song")
+
+	skip := func(user string, want string) {
+		t.Helper()
+		i := slashCommand("skip", user)
+		runCommand(s, instance, i, configs)
+		if got := response(t, s, i); got != want {
+			t.Fatalf("%s skips: response %q, want %q", user, got, want)
+		}
+	}
+
+	skip("b", "Vote registered: 1/2 votes needed to skip")
+
+	s.SetVoiceState(testGuild, "b", "")
+	skip("c", "Vote registered: 1/2 votes needed to skip")
+	skip("c", "Vote registered: 1/2 votes needed to skip")
+
+	skip("d", "Song has been skipped")
+}
+
+// TestVoteSkipWithoutDJRole skips right away: without a dj role everyone can control the player
+func TestVoteSkipWithoutDJRole(t *testing.T) {
+	configs := testConfig()
+
+	s, instance := newTestServer(t, 5000, "a", "b", "c")
+	i := slashCommand("play", "a", stringOption("input", "https://youtu.be/"+testVideos[0].ID))
+	runCommand(s, instance, i, configs)
+	response(t, s, i)
+	nowPlaying(t, s, "First song")
+
+	i = slashCommand("skip", "b")
+	runCommand(s, instance, i, configs)
+	if got := response(t, s, i); got != "Song has been skipped" {
+		t.Fatalf("response %q", got)
+	}
+}
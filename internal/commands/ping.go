@@ -2,10 +2,11 @@ package commands
 
 import (
 	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
 	"github.com/matthew-balzan/eido/internal/models"
 )
 
-func PingCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func PingCommand(s discord.Session, i *discordgo.InteractionCreate, _ *ServerInstance, _ *models.Config) {
 	responseMessage := "Pong!"
 	SendSimpleMessageResponse(s, i, responseMessage, models.ColorDefault)
 }
@@ -0,0 +1,313 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/youtube"
+)
+
+// playlistImport is a playlist being added to the queue in the background
+type playlistImport struct {
+	id          string // id of the interaction that started it, used by the cancel button
+	requesterId string
+	cancel      context.CancelFunc
+}
+
+// playlistRange are the songs of the playlist to add, from the options of the play command
+type playlistRange struct {
+	start int // position of the first song, from 1
+	end   int // position of the last song, 0 for the end of the playlist
+	limit int // maximum number of songs added, 0 for no limit
+	skip  int // songs skipped after the start
+}
+
+func parsePlaylistRange(optionMap map[string]*discordgo.ApplicationCommandInteractionDataOption) (r playlistRange) {
+	r.start = 1
+	if opt := optionMap["start"]; opt != nil {
+		r.start = int(opt.IntValue())
+	}
+	if opt := optionMap["end"]; opt != nil {
+		r.end = int(opt.IntValue())
+	}
+	if opt := optionMap["limit"]; opt != nil {
+		r.limit = int(opt.IntValue())
+	}
+	if opt := optionMap["skip-playlist"]; opt != nil {
+		r.skip = int(opt.IntValue())
+	}
+	return r
+}
+
+// importProgress counts the songs of the playlist read so far
+type importProgress struct {
+	url         string
+	total       int // songs in the range of the playlist
+	resolved    int // songs read
+	added       int // songs added to the queue
+	queueFull   int // songs not added because the queue was full
+	duration    time.Duration
+	unknown     bool // true if the duration of some songs is not known
+	unavailable []youtube.Unavailable
+}
+
+func playCommandPlaylist(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, channelId string, urlPlaylist string, r playlistRange, configs *models.Config) {
+
+	id := youtubePlaylistID(urlPlaylist)
+	if id == "" {
+		respondError(s, i, errUnsupported, "playlist")
+		return
+	}
+
+	if r.start < 1 || r.end < 0 || r.limit < 0 || r.skip < 0 || (r.end > 0 && r.end < r.start) {
+		SendSimpleMessageResponse(s, i, "Invalid range: `start` and `end` are positions in the playlist from 1, with `end` not before `start`", models.ColorError)
+		return
+	}
+
+	importing := false
+	instance.Do(func() {
+		importing = instance.Voice.importing != nil
+	})
+	if importing {
+		SendSimpleMessageResponse(s, i, "Another playlist is being added, wait for it to end or cancel it", models.ColorError)
+		return
+	}
+
+	if !instance.Voice.joinSession(s, i.GuildID, channelId, i.ChannelID, configs) {
+		SendSimpleMessageResponse(s, i, "Couldn't join the voice channel", models.ColorError)
+		return
+	}
+
+	var ctx context.Context
+	var job *playlistImport
+	progress := &importProgress{url: urlPlaylist}
+
+	instance.Do(func() {
+		// the other commands ran while joining
+		if !checkAudioBasicPrerequisites(s, i, instance, channelId, true) {
+			return
+		}
+		if instance.Voice.importing != nil {
+			SendSimpleMessageResponse(s, i, "Another playlist is being added, wait for it to end or cancel it", models.ColorError)
+			return
+		}
+		if instance.Voice.Queue == nil { // disconnected in the meantime
+			SendSimpleMessageResponse(s, i, "Couldn't join the voice channel", models.ColorError)
+			return
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		job = &playlistImport{id: i.ID, requesterId: i.Member.User.ID, cancel: cancel}
+		instance.Voice.importing = job
+
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Embeds:     []*discordgo.MessageEmbed{progress.embed(false, false)},
+				Components: importComponents(job),
+			},
+		})
+	})
+	if job == nil {
+		return
+	}
+
+	sessions.Add(1)
+	go func() {
+		defer sessions.Done()
+		instance.Voice.importPlaylist(ctx, s, i, job, id, r, progress, configs)
+	}()
+}
+
+// importPlaylist adds the songs of the playlist to the queue a page at a time, so the first ones play while the others are read.
+// It updates the progress in the response to the command. It runs outside the loop of the server
+func (v *VoiceInstance) importPlaylist(ctx context.Context, s discord.Session, i *discordgo.InteractionCreate, job *playlistImport, id string, r playlistRange, progress *importProgress, configs *models.Config) {
+	defer job.cancel()
+
+	first := r.start - 1 + r.skip
+	var lastEdit time.Time
+
+	err := v.youtube(configs).PlaylistRange(ctx, id, first, r.end, func(page youtube.Playlist, total int) bool {
+		stop := false
+
+		v.do(func() {
+			if v.importing != job { // cancelled, or disconnected
+				stop = true
+				return
+			}
+
+			for _, entry := range page.Videos {
+				if r.limit > 0 && progress.added >= r.limit {
+					stop = true
+					return
+				}
+
+				song := Song{
+					url:           "https://www.youtube.com/watch?v=" + entry.ID,
+					videoInfo:     VideoInfo(entry),
+					requesterId:   i.Member.User.ID,
+					requesterName: i.Member.User.Username,
+				}
+
+				if !v.addToQueue(song) {
+					progress.queueFull++
+					continue
+				}
+
+				progress.added++
+				if duration, ok := parseSongDuration(entry.Duration); ok {
+					progress.duration += duration
+				} else {
+					progress.unknown = true
+				}
+			}
+		})
+
+		progress.total = total
+		progress.resolved += len(page.Videos) + len(page.Unavailable)
+		progress.unavailable = append(progress.unavailable, page.Unavailable...)
+
+		// discord limits the edits of a message, the last update is always shown at the end
+		if time.Since(lastEdit) > time.Second {
+			lastEdit = time.Now()
+			embeds := []*discordgo.MessageEmbed{progress.embed(false, false)}
+			s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &embeds})
+		}
+
+		return !stop && ctx.Err() == nil
+	})
+
+	v.endImport(s, i, job, progress, err)
+}
+
+// endImport shows the result of the import in the response to the command, and removes the cancel button
+func (v *VoiceInstance) endImport(s discord.Session, i *discordgo.InteractionCreate, job *playlistImport, progress *importProgress, err error) {
+	cancelled := false
+	v.do(func() {
+		if v.importing == job {
+			v.importing = nil
+		} else {
+			cancelled = true
+		}
+	})
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Println("ERR: internal/commands/playlist.go: Error reading the playlist - ", err)
+	}
+
+	embed := progress.embed(true, cancelled)
+	if err != nil && !cancelled {
+		embed.Color = models.ColorError
+		if progress.resolved == 0 {
+			embed.Description = errorMessage(err, "playlist") + "\n\n" + progress.url
+		} else {
+			embed.Description += "\n\nThe rest of the playlist couldn't be read"
+		}
+	}
+
+	embeds := []*discordgo.MessageEmbed{embed}
+	components := []discordgo.MessageComponent{}
+	s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Embeds: &embeds, Components: &components})
+}
+
+// embed returns the progress of the import, or its result when `done`
+func (p *importProgress) embed(done bool, cancelled bool) *discordgo.MessageEmbed {
+	total := "?"
+	if p.total > 0 {
+		total = strconv.Itoa(p.total)
+	}
+	failed := len(p.unavailable) + p.queueFull
+
+	duration := formatDuration(p.duration)
+	if p.unknown {
+		duration += "+"
+	}
+
+	var message string
+	switch {
+	case cancelled:
+		message = "Playlist import cancelled"
+	case done:
+		message = "Playlist added to queue"
+	default:
+		message = "Adding playlist ..."
+	}
+	message += "\n\n" + p.url + "\n\n" +
+		strconv.Itoa(p.resolved) + "/" + total + " resolved, " + strconv.Itoa(failed) + " failed\n" +
+		strconv.Itoa(p.added) + " songs added, " + duration
+
+	color := models.ColorDefault
+	if done {
+		if p.queueFull > 0 {
+			message += "\n\nOne or more videos have not been added. Check if you went over the limit of the queue (" + strconv.Itoa(models.MaxQueueLength) + ")"
+			color = models.ColorError
+		}
+		if len(p.unavailable) > 0 {
+			message += "\n\n" + unavailableMessage(p.unavailable)
+		}
+	}
+
+	return &discordgo.MessageEmbed{Description: message, Color: color}
+}
+
+func importComponents(job *playlistImport) []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "Cancel",
+					Style:    discordgo.DangerButton,
+					CustomID: "import:" + job.id,
+				},
+			},
+		},
+	}
+}
+
+// importCancelComponent stops the import of the playlist, when its cancel button is pressed.
+// It can be used by who added the playlist, or by who can clear the queue
+func importCancelComponent(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config, args []string) {
+	var job *playlistImport
+	if instance != nil { // nil in direct messages, where there are no imports
+		job = instance.Voice.importing
+	}
+	if job == nil || len(args) == 0 || job.id != args[0] {
+		SendSimpleMessageResponse(s, i, "This playlist is not being added anymore", models.ColorError)
+		return
+	}
+
+	if job.requesterId != InteractionUser(i).ID && !hasCommandPermission(s, i, FindCommand("clear"), configs) {
+		SendSimpleMessageResponse(s, i, "Only who added the playlist can cancel it", models.ColorError)
+		return
+	}
+
+	instance.Voice.cancelImport()
+
+	// the message is updated when the import stops
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+}
+
+// maxUnavailableShown is the number of unavailable videos listed in the messages, the others are only counted
+const maxUnavailableShown = 10
+
+// unavailableMessage lists the videos of a playlist that were skipped, with the reason
+func unavailableMessage(unavailable []youtube.Unavailable) (message string) {
+	message = strconv.Itoa(len(unavailable)) + " videos skipped because they can't be played:"
+	for j, video := range unavailable {
+		if j == maxUnavailableShown {
+			message += "\n... and " + strconv.Itoa(len(unavailable)-j) + " more"
+			break
+		}
+		message += "\n- " + video.Title + " *(" + video.Reason + ")*"
+	}
+	return message
+}
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"github.com/matthew-balzan/eido/internal/audio"
+)
+
+// refreshPrefetch makes sure the next song in the queue is being downloaded and encoded while the current one plays,
+// so that it can start right after. A prefetched song that's not the next one anymore is cancelled.
+// The prefetched song is opened after the stream playing, to mix them with the crossfade
+func (v *VoiceInstance) refreshPrefetch() {
+	var next *Song
+	if v.IsPlaying && len(v.QueueList) > 1 {
+		next = &v.QueueList[1]
+	}
+
+	if v.Prefetch != nil && next != nil && v.Prefetch.URL == next.url && (v.current == nil || v.prefetchAfter == v.current) {
+		return
+	}
+
+	v.cancelPrefetch()
+
+	if next == nil || v.configs == nil {
+		return
+	}
+
+	stream, err := v.openAudioStream(*next, 0, v.current, v.encodingProfile(v.configs), v.configs)
+	if err != nil {
+		return
+	}
+	v.Prefetch = stream
+	v.prefetchAfter = v.current
+}
+
+// takePrefetch returns the prefetched stream if it's the one of the song, nil otherwise
+func (v *VoiceInstance) takePrefetch(url string) (stream *audio.Stream) {
+	if v.Prefetch != nil && v.Prefetch.URL == url {
+		stream = v.Prefetch
+		v.Prefetch = nil
+		v.prefetchAfter = nil
+		return stream
+	}
+
+	v.cancelPrefetch()
+	return nil
+}
+
+func (v *VoiceInstance) cancelPrefetch() {
+	if v.Prefetch != nil {
+		go v.Prefetch.Close()
+		v.Prefetch = nil
+		v.prefetchAfter = nil
+	}
+}
@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matthew-balzan/eido/internal/audio"
+)
+
+// trackedSource plays silence and counts the sources opened and closed for each video
+type trackedSource struct {
+	lock   sync.Mutex
+	opened map[string]int
+	closed map[string]int
+}
+
+func newTrackedSource() *trackedSource {
+	return &trackedSource{opened: map[string]int{}, closed: map[string]int{}}
+}
+
+func (t *trackedSource) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	id := youtubeVideoID(url)
+
+	t.lock.Lock()
+	t.opened[id]++
+	t.lock.Unlock()
+
+	return &trackedReader{Reader: &silence{frames: 5000}, close: func() {
+		t.lock.Lock()
+		t.closed[id]++
+		t.lock.Unlock()
+	}}, nil
+}
+
+// counts returns how many sources of the video were opened and closed
+func (t *trackedSource) counts(id string) (opened int, closed int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.opened[id], t.closed[id]
+}
+
+type trackedReader struct {
+	io.Reader
+	close func()
+}
+
+func (t *trackedReader) Close() error {
+	t.close()
+	return nil
+}
+
+// waitPrefetch waits until the song prefetched is the video, or none if empty
+func waitPrefetch(t *testing.T, instance *ServerInstance, id string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var url string
+		instance.Do(func() {
+			if instance.Voice.Prefetch != nil {
+				url = instance.Voice.Prefetch.URL
+			}
+		})
+		if (id == "" && url == "") || (id != "" && strings.Contains(url, id)) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("prefetched song is not %q after 5s", id)
+}
+
+// waitClosed waits until the sources of the video opened are closed, but `open`
+func waitClosed(t *testing.T, source *trackedSource, id string, open int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if opened, closed := source.counts(id); opened-closed == open {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	opened, closed := source.counts(id)
+	t.Fatalf("%d sources of %s opened and %d closed, want %d still open", opened, id, closed, open)
+}
+
+func TestPrefetchQueueChange(t *testing.T) {
+	configs := testConfig()
+
+	s, instance := newTestServer(t, 5000, "a", "b")
+	source := newTrackedSource()
+	instance.Voice.Pipeline.Source = source
+	instance.Do(func() {
+		instance.Voice.FairQueue = true
+	})
+	first, second := testVideos[0].ID, testVideos[1].ID
+
+	play := func(user string, id string) {
+		clearCooldowns() // a user adds more than one song
+		i := slashCommand("play", user, stringOption("input", "https://youtu.be/"+id))
+		runCommand(s, instance, i, configs)
+		response(t, s, i)
+	}
+
+	play("a", first)
+	nowPlaying(t, s, "First song")
+	play("a", second)
+	waitPrefetch(t, instance, second)
+
+	// the fair queue moves the song of b before the second song of a
+	play("b", first)
+	waitPrefetch(t, instance, first)
+	waitClosed(t, source, second, 0)
+	waitClosed(t, source, first, 2) // playing and prefetched
+
+	// clearing the queue cancels the prefetch too
+	clear := slashCommand("clear", "a")
+	runCommand(s, instance, clear, configs)
+	response(t, s, clear)
+	waitPrefetch(t, instance, "")
+	waitClosed(t, source, first, 0)
+}
+
+// TestPrefetchFollowsStream checks that the song prefetched is opened again after a new stream of the song playing,
+// so the crossfade mixes it with the stream that's playing
+func TestPrefetchFollowsStream(t *testing.T) {
+	configs := testConfig()
+
+	s, instance := newTestServer(t, 5000, "a")
+	play := func(id string) {
+		clearCooldowns()
+		i := slashCommand("play", "a", stringOption("input", "https://youtu.be/"+id))
+		runCommand(s, instance, i, configs)
+		response(t, s, i)
+	}
+
+	play(testVideos[0].ID)
+	nowPlaying(t, s, "First song")
+	play(testVideos[1].ID)
+	waitPrefetch(t, instance, testVideos[1].ID)
+
+	var prefetched *audio.Stream
+	instance.Do(func() {
+		v := instance.Voice
+		prefetched = v.Prefetch
+		if v.prefetchAfter != v.current {
+			t.Error("the song was not prefetched after the stream playing")
+		}
+
+		// the song playing is opened again, like after an error of the stream
+		playing := v.current
+		v.current = &audio.Stream{}
+		v.refreshPrefetch()
+		if v.Prefetch == prefetched || v.prefetchAfter != v.current {
+			t.Error("the song was not prefetched again after the new stream")
+		}
+		v.current = playing
+	})
+}
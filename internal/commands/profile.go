@@ -0,0 +1,48 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// ProfileCommand chooses the encoding profile of the server.
+// Without the `name` option it shows the current profile and the available ones
+func ProfileCommand(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, configs *models.Config) {
+	v := instance.Voice
+	names := strings.Join(configs.ProfileNames(), ", ")
+
+	opt := parseOptions(i)["name"]
+	if opt == nil {
+		name := v.Profile
+		if _, ok := configs.Profile(name); !ok {
+			name = configs.EncodingProfile
+		}
+		profile := v.encodingProfile(configs)
+
+		message := "Encoding profile: **" + name + "** (" + strconv.Itoa(profile.Bitrate) + " kbps, " + profile.Application + ")"
+		if audio.CanPassthrough(encodeOptions(0, profile)) && configs.CrossfadeSeconds == 0 {
+			message += "\nSongs in opus are sent without encoding them again, when they play from the start"
+		}
+		SendSimpleMessageResponse(s, i, message+"\nAvailable: "+names, models.ColorDefault)
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(opt.StringValue()))
+	if _, ok := configs.Profile(name); !ok {
+		SendSimpleMessageResponse(s, i, "Unknown profile **"+name+"**. Available: "+names, models.ColorError)
+		return
+	}
+
+	v.Profile = name
+
+	// the prefetched song was encoded with the old profile
+	v.cancelPrefetch()
+	v.refreshPrefetch()
+
+	SendSimpleMessageResponse(s, i, "Encoding profile set to **"+name+"**, starting from the next song", models.ColorDefault)
+}
@@ -0,0 +1,48 @@
+package commands
+
+import "sync"
+
+// Registry holds the instances of the servers. It's safe for concurrent use
+type Registry struct {
+	lock      sync.Mutex
+	instances map[string]*ServerInstance
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		instances: map[string]*ServerInstance{},
+	}
+}
+
+// Get returns the instance of the server, nil if it doesn't exist
+func (r *Registry) Get(id string) *ServerInstance {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.instances[id]
+}
+
+// GetOrCreate returns the instance of the server, creating it if it doesn't exist
+func (r *Registry) GetOrCreate(id string) *ServerInstance {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	instance := r.instances[id]
+	if instance == nil {
+		instance = CreateServerInstance(id)
+		r.instances[id] = instance
+	}
+	return instance
+}
+
+// All returns the instances of every server
+func (r *Registry) All() (list []*ServerInstance) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	list = make([]*ServerInstance, 0, len(r.instances))
+	for _, instance := range r.instances {
+		list = append(list, instance)
+	}
+	return list
+}
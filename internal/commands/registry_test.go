@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// The tests of the registry and of the loop of the servers run the commands from many goroutines, run them with -race
+
+func TestRegistryGetOrCreate(t *testing.T) {
+	r := NewRegistry()
+
+	var wg sync.WaitGroup
+	got := make([]*ServerInstance, 100)
+	for j := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[j] = r.GetOrCreate("guild" + strconv.Itoa(j%4))
+		}()
+	}
+	wg.Wait()
+
+	for j, instance := range got {
+		if instance != r.Get("guild"+strconv.Itoa(j%4)) {
+			t.Fatalf("two instances created for guild%d", j%4)
+		}
+	}
+	if all := r.All(); len(all) != 4 {
+		t.Fatalf("%d instances, want 4", len(all))
+	}
+	if r.Get("other") != nil {
+		t.Fatal("Get created an instance")
+	}
+}
+
+// TestConcurrentActions adds, reorders and clears songs from many commands at once,
+// while a player takes them from the queue like the one of a session
+func TestConcurrentActions(t *testing.T) {
+	instance := CreateServerInstance("guild")
+	v := instance.Voice
+
+	instance.Do(func() {
+		v.Queue = make(chan Song, models.MaxQueueLength)
+	})
+
+	stop := make(chan struct{})
+	played := make(chan struct{})
+	go func() {
+		defer close(played)
+		for {
+			select {
+			case <-v.Queue:
+				v.do(func() {
+					if len(v.QueueList) > 0 {
+						v.QueueList = v.QueueList[1:]
+					}
+				})
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for j := 0; j < 50; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			song := Song{url: "song" + strconv.Itoa(j), requesterId: "user" + strconv.Itoa(j%3)}
+
+			instance.Do(func() {
+				v.FairQueue = j%2 == 0
+				v.addToQueue(song)
+				if j%10 == 0 {
+					v.clearQueue()
+				}
+			})
+		}()
+	}
+	wg.Wait()
+
+	close(stop)
+	<-played
+
+	instance.Do(func() {
+		v.clearQueue()
+		if len(v.QueueList) != 0 || len(v.Queue) != 0 {
+			t.Fatalf("%d songs in the queue list, %d waiting to play after a clear", len(v.QueueList), len(v.Queue))
+		}
+	})
+}
+
+func TestDoRecoversPanic(t *testing.T) {
+	instance := CreateServerInstance("guild")
+
+	instance.Do(func() {
+		panic("command failed")
+	})
+
+	ran := false
+	instance.Do(func() {
+		ran = true
+	})
+	if !ran {
+		t.Fatal("the loop stopped after a panic")
+	}
+}
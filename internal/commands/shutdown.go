@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// savedSession returns the session to resume at the next start, nil if the bot has nothing to play
+func (v *VoiceInstance) savedSession() *SavedSession {
+	if v.Connection == nil || len(v.QueueList) == 0 {
+		return v.saved // the one of the last shutdown, if it wasn't resumed
+	}
+
+	session := &SavedSession{
+		VoiceChannelId: v.ChannelId,
+		TextChannelId:  v.TextChannelId,
+		Queue:          make([]SavedSong, 0, len(v.QueueList)),
+	}
+	if v.IsPlaying {
+		session.PositionSeconds = int(v.playbackPosition().Seconds())
+	}
+
+	for _, song := range v.QueueList {
+		session.Queue = append(session.Queue, SavedSong{
+			URL:           song.url,
+			ID:            song.videoInfo.ID,
+			Title:         song.videoInfo.Title,
+			Author:        song.videoInfo.Author,
+			Duration:      song.videoInfo.Duration,
+			Thumbnail:     song.videoInfo.Thumbnail,
+			RequesterId:   song.requesterId,
+			RequesterName: song.requesterName,
+			Autoplay:      song.autoplay,
+		})
+	}
+	return session
+}
+
+func (saved SavedSong) song() Song {
+	return Song{
+		url: saved.URL,
+		videoInfo: VideoInfo{
+			ID:        saved.ID,
+			Title:     saved.Title,
+			Author:    saved.Author,
+			Duration:  saved.Duration,
+			Thumbnail: saved.Thumbnail,
+		},
+		autoplay:      saved.Autoplay,
+		requesterId:   saved.RequesterId,
+		requesterName: saved.RequesterName,
+	}
+}
+
+// StopSessions ends the voice sessions of every server at shutdown: the text channels are told,
+// the songs are stopped and the bot leaves the voice channels.
+// The servers that don't answer before the context ends are left as they are.
+// The queues have to be saved before, with ExportState
+func (r *Registry) StopSessions(ctx context.Context, s discord.Messenger) {
+	var wg sync.WaitGroup
+	for _, instance := range r.All() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			answered := instance.DoContext(ctx, func() {
+				v := instance.Voice
+				if v.Connection == nil {
+					return
+				}
+
+				message := "The bot is shutting down"
+				if len(v.QueueList) > 0 {
+					message += ". The queue is saved and will continue when it's back"
+				}
+				SendSimpleMessageToChannel(s, v.TextChannelId, message, models.ColorDefault)
+
+				v.skip()
+				v.disconnect()
+			})
+			if !answered {
+				log.Println("ERR: internal/commands/shutdown.go: Server " + instance.ServerId + " didn't answer, its session is not stopped")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ResumeSession joins again the voice channel of the session saved at the last shutdown,
+// and plays its queue from where it stopped. It's called when the server becomes available,
+// so the users in the voice channel are already known.
+// It runs outside the loop of the server, like the play command
+func ResumeSession(s discord.Session, instance *ServerInstance, configs *models.Config) {
+	v := instance.Voice
+
+	var saved *SavedSession
+	instance.Do(func() {
+		if v.Connection == nil {
+			saved = v.saved
+		}
+		v.saved = nil
+	})
+	if saved == nil || len(saved.Queue) == 0 {
+		return
+	}
+
+	joined := v.joinSession(s, instance.ServerId, saved.VoiceChannelId, saved.TextChannelId, configs)
+
+	instance.Do(func() {
+		if !joined || v.Queue == nil {
+			SendSimpleMessageToChannel(s, saved.TextChannelId, "Couldn't join the voice channel to continue the queue", models.ColorError)
+			return
+		}
+
+		for j, item := range saved.Queue {
+			song := item.song()
+			if j == 0 {
+				song.start = time.Duration(saved.PositionSeconds) * time.Second
+			}
+			v.addToQueue(song)
+		}
+
+		log.Println("Session resumed with " + strconv.Itoa(len(saved.Queue)) + " songs")
+		SendSimpleMessageToChannel(s, saved.TextChannelId, "Back online, continuing the queue", models.ColorDefault)
+	})
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthew-balzan/eido/internal/discord/fake"
+)
+
+// TestShutdownStuckServer saves the state and stops the sessions while another server is stuck in an action
+func TestShutdownStuckServer(t *testing.T) {
+	configs := testConfig()
+
+	s, instance := newTestServer(t, 5000, "user")
+	i := slashCommand("play", "user", stringOption("input", "https://youtu.be/"+testVideos[0].ID))
+	runCommand(s, instance, i, configs)
+	response(t, s, i)
+	nowPlaying(t, s, "First song")
+
+	stuck := CreateServerInstance("stuck")
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go stuck.Do(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	t.Cleanup(func() {
+		close(release)
+	})
+
+	r := NewRegistry()
+	r.instances[testGuild] = instance
+	r.instances["stuck"] = stuck
+
+	start := time.Now()
+
+	// like at shutdown, saving and stopping have their own time
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	state := r.ExportState(ctx)
+	if len(state.Guilds) != 1 || state.Guilds[0].GuildId != testGuild {
+		t.Fatalf("state saved %+v, want only the server that answered", state.Guilds)
+	}
+	if session := state.Guilds[0].Session; session == nil || len(session.Queue) != 1 || session.Queue[0].Title != "First song" {
+		t.Fatalf("session saved %+v", session)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	r.StopSessions(ctx, s)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("shutdown took %s, waiting for the stuck server", elapsed)
+	}
+
+	waitFor(t, s, "shutdown message", func(message fake.Message) bool {
+		return strings.HasPrefix(message.Embeds[0].Title+message.Embeds[0].Description, "The bot is shutting down")
+	})
+	if connections := s.Connections(); len(connections) != 1 || !connections[0].IsDisconnected() {
+		t.Fatal("the bot didn't leave the voice channel")
+	}
+}
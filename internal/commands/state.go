@@ -0,0 +1,148 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/youtube"
+)
+
+// GuildState is the data of a server saved between restarts
+type GuildState struct {
+	GuildId   string `json:"guildId"`
+	Autoplay  bool   `json:"autoplay"`
+	FairQueue bool   `json:"fairQueue"`
+	Profile   string `json:"profile,omitempty"`
+
+	IdleTimeoutMinutes int `json:"idleTimeoutMinutes,omitempty"`
+
+	Session *SavedSession `json:"session,omitempty"` // voice session interrupted by the shutdown, resumed at startup
+}
+
+// SavedSession is a voice session saved at shutdown, with its queue
+type SavedSession struct {
+	VoiceChannelId  string      `json:"voiceChannelId"`
+	TextChannelId   string      `json:"textChannelId"`
+	PositionSeconds int         `json:"positionSeconds,omitempty"` // position in the first song of the queue
+	Queue           []SavedSong `json:"queue"`
+}
+
+// SavedSong is a song of a saved queue
+type SavedSong struct {
+	URL           string `json:"url"`
+	ID            string `json:"id"`
+	Title         string `json:"title"`
+	Author        string `json:"author,omitempty"`
+	Duration      string `json:"duration,omitempty"`
+	Thumbnail     string `json:"thumbnail,omitempty"`
+	RequesterId   string `json:"requesterId,omitempty"`
+	RequesterName string `json:"requesterName,omitempty"`
+	Autoplay      bool   `json:"autoplay,omitempty"`
+}
+
+// State is the data of all the servers saved between restarts
+type State struct {
+	Guilds       []GuildState        `json:"guilds"`
+	YoutubeQuota *youtube.QuotaState `json:"youtubeQuota,omitempty"` // quota spent today, so a restart doesn't reset it
+}
+
+// ExportState returns the data to save of every server.
+// The servers that don't answer before the context ends are left out
+func (r *Registry) ExportState(ctx context.Context) (state State) {
+	state.Guilds = []GuildState{}
+	if ytClient != nil {
+		quota := ytClient.Quota().State()
+		state.YoutubeQuota = &quota
+	}
+
+	instances := r.All()
+	guilds := make([]*GuildState, len(instances))
+
+	// a server busy or stuck doesn't hold the others
+	var wg sync.WaitGroup
+	for j, instance := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var guild GuildState
+			answered := instance.DoContext(ctx, func() {
+				guild = GuildState{
+					GuildId:   instance.ServerId,
+					Autoplay:  instance.Voice.Autoplay,
+					FairQueue: instance.Voice.FairQueue,
+					Profile:   instance.Voice.Profile,
+
+					IdleTimeoutMinutes: instance.Voice.IdleTimeoutMinutes,
+
+					Session: instance.Voice.savedSession(),
+				}
+			})
+			if !answered {
+				log.Println("ERR: internal/commands/state.go: Server " + instance.ServerId + " didn't answer, its state is not saved")
+				return
+			}
+			guilds[j] = &guild
+		}()
+	}
+	wg.Wait()
+
+	for _, guild := range guilds {
+		if guild != nil {
+			state.Guilds = append(state.Guilds, *guild)
+		}
+	}
+	return state
+}
+
+// ImportState restores the data of the servers
+func (r *Registry) ImportState(state State) {
+	if ytClient != nil && state.YoutubeQuota != nil {
+		ytClient.Quota().Restore(*state.YoutubeQuota)
+	}
+
+	for _, guild := range state.Guilds {
+		instance := r.GetOrCreate(guild.GuildId)
+		instance.Do(func() {
+			instance.Voice.Autoplay = guild.Autoplay
+			instance.Voice.FairQueue = guild.FairQueue
+			instance.Voice.Profile = guild.Profile
+			instance.Voice.IdleTimeoutMinutes = min(max(guild.IdleTimeoutMinutes, 0), models.MaxIdleTimeoutMinutes)
+			instance.Voice.saved = guild.Session
+		})
+	}
+}
+
+// ReadStateFile reads the state saved in the file. A missing file is an empty state
+func ReadStateFile(path string) (state State, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{Guilds: []GuildState{}}, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+// WriteStateFile saves the state in the file
+func WriteStateFile(path string, state State) (err error) {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so that a crash never leaves a half written file
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/youtube"
+)
+
+// Metrics are the numbers that show how much memory the bot is using, and the youtube quota spent
+type Metrics struct {
+	Streams    audio.StreamStats
+	Songs      audio.MemoryStats // memory reserved by the songs of all the servers
+	Process    runtime.MemStats
+	Goroutines int
+	Youtube    youtube.ClientStats
+}
+
+func CollectMetrics() (m Metrics) {
+	m.Streams = audio.GetStreamStats()
+	m.Songs = globalMemory.Stats()
+	runtime.ReadMemStats(&m.Process)
+	m.Goroutines = runtime.NumGoroutine()
+	if ytClient != nil {
+		m.Youtube = ytClient.Stats()
+	}
+	return m
+}
+
+// String returns the metrics in one line, for the logs
+func (m Metrics) String() string {
+	return fmt.Sprintf(
+		"streams=%d buffered=%s/%s songs_memory=%s peak=%s limit=%s heap=%s sys=%s goroutines=%d gc=%d youtube_quota=%d/%d youtube_fallbacks=%d",
+		m.Streams.Streams, formatBytes(m.Streams.BufferedBytes), formatBytes(m.Streams.BufferBytes),
+		formatBytes(m.Songs.Used), formatBytes(m.Songs.Peak), formatBytes(m.Songs.Limit),
+		formatBytes(int64(m.Process.HeapInuse)), formatBytes(int64(m.Process.Sys)), m.Goroutines, m.Process.NumGC,
+		m.Youtube.Quota.Used, m.Youtube.Quota.Budget, m.Youtube.Fallbacks,
+	)
+}
+
+// StatsCommand shows the memory used by the bot and by the server, and the youtube quota spent today
+func StatsCommand(s discord.Session, i *discordgo.InteractionCreate, instance *ServerInstance, _ *models.Config) {
+	m := CollectMetrics()
+	guild := instance.Voice.memory.Stats()
+
+	message := "**Streams open:** " + fmt.Sprint(m.Streams.Streams) +
+		" (" + formatBytes(m.Streams.BufferedBytes) + " buffered of " + formatBytes(m.Streams.BufferBytes) + ")\n" +
+		"**Songs memory:** " + formatMemoryStats(m.Songs) + "\n" +
+		"**This server:** " + formatMemoryStats(guild) + "\n" +
+		"**Process:** " + formatBytes(int64(m.Process.HeapInuse)) + " heap, " + formatBytes(int64(m.Process.Sys)) + " from the system, " +
+		fmt.Sprint(m.Goroutines) + " goroutines"
+	if ytClient != nil {
+		message += "\n**Youtube api:** " + formatYoutubeStats(m.Youtube)
+	}
+
+	SendSimpleMessageResponse(s, i, message, models.ColorDefault)
+}
+
+func formatMemoryStats(stats audio.MemoryStats) string {
+	return formatBytes(stats.Used) + " used, " + formatBytes(stats.Peak) + " peak, " + formatBytes(stats.Limit) + " limit"
+}
+
+// formatBytes returns the size in the largest unit that keeps it above 1
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	value, exp := float64(n)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exp])
+}
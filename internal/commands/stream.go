@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthew-balzan/dca"
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// defaultPipeline downloads the songs with yt-dlp and encodes them with ffmpeg.
+// Opus songs that don't need changes are sent without encoding them again
+func defaultPipeline() *audio.Pipeline {
+	return &audio.Pipeline{
+		Source:      audio.YtdlpSource{Format: models.DefaultYtdlpFormat},
+		Encoder:     audio.PassthroughEncoder{Fallback: audio.FfmpegEncoder{}},
+		Decoder:     audio.FfmpegDecoder{},
+		BufferBytes: models.DefaultStreamBufferKB * 1024,
+	}
+}
+
+// globalMemory is the memory budget of all the servers, the budget of each server is inside it
+var globalMemory = audio.NewMemoryBudget(int64(models.DefaultMemoryLimitMB)<<20, nil)
+
+// encodingProfile returns the profile chosen by the server, or the one of the config,
+// with the bitrate set for the voice channel
+func (v *VoiceInstance) encodingProfile(configs *models.Config) (profile models.EncodingProfile) {
+	profile, ok := configs.Profile(v.Profile)
+	if !ok { // not chosen, or removed from the config
+		profile, ok = configs.Profile(configs.EncodingProfile)
+	}
+	if !ok {
+		profile = models.EncodingProfiles[models.DefaultEncodingProfile]
+	}
+
+	profile.Bitrate = profile.BitrateFor(v.channelBitrate)
+	return profile
+}
+
+// openAudioStream starts downloading and encoding the song from the `start` position.
+// The frames are buffered until the stream is sent to a voice connection.
+// With a crossfade, the start of the song is mixed with the end of `previous`, if not nil
+func (v *VoiceInstance) openAudioStream(song Song, start time.Duration, previous *audio.Stream, profile models.EncodingProfile, configs *models.Config) (stream *audio.Stream, err error) {
+	// the limits follow the config, which can be reloaded
+	globalMemory.SetLimit(int64(configs.MemoryLimitMB) << 20)
+	v.memory.SetLimit(int64(configs.GuildMemoryLimitMB) << 20)
+
+	pipeline := *v.Pipeline
+	pipeline.BufferBytes = configs.StreamBufferKB * 1024
+	pipeline.Budget = v.memory
+	pipeline.Crossfade = time.Duration(configs.CrossfadeSeconds) * time.Second
+	pipeline.Source, _ = profileSource(pipeline.Source, profile)
+
+	return pipeline.OpenAfter(previous, song.url, encodeOptions(start, profile))
+}
+
+// encodeOptions returns the ffmpeg options for the song, starting from the `start` position.
+// Without filters the song can be played without encoding it again
+func encodeOptions(start time.Duration, profile models.EncodingProfile) *dca.EncodeOptions {
+	options := *dca.StdEncodeOptions
+	options.RawOutput = true
+	options.StartTime = int(start.Seconds())
+	options.Bitrate = profile.Bitrate
+	options.Application = dca.AudioApplication(profile.Application)
+	options.BufferedFrames = profile.BufferedFrames
+
+	var filters []string
+	if profile.Volume != 1 {
+		filters = append(filters, "volume="+strconv.FormatFloat(profile.Volume, 'f', -1, 64))
+	}
+
+	options.AudioFilter = strings.Join(filters, ",")
+	return &options
+}
+
+var regDurationYoutube = regexp.MustCompile(`^(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+
+// parseSongDuration parses the durations shown to the user,
+// either in the youtube format without "PT" (ex. "3M20S") or in the yt-dlp format (ex. "3:20")
+func parseSongDuration(input string) (duration time.Duration, res bool) {
+	if input == "" {
+		return 0, false
+	}
+
+	if strings.Contains(input, ":") {
+		for _, part := range strings.Split(input, ":") {
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, false
+			}
+			duration = duration*60 + time.Duration(n)*time.Second
+		}
+		return duration, true
+	}
+
+	match := regDurationYoutube.FindStringSubmatch(input)
+	if match == nil {
+		return 0, false
+	}
+
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	for j, unit := range units {
+		if match[j+1] != "" {
+			n, _ := strconv.Atoi(match[j+1])
+			duration += time.Duration(n) * unit
+		}
+	}
+	return duration, true
+}
+
+// formatDuration returns the duration as shown to the user, ex. "3:20" or "1:02:03"
+func formatDuration(duration time.Duration) string {
+	seconds := int(duration.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
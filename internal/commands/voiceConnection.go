@@ -0,0 +1,189 @@
+package commands
+
+import (
+	"log"
+	"time"
+
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// voiceCheck asks the monitor of the voice connection to check it now
+type voiceCheck struct {
+	failed discord.VoiceConnection // connection that couldn't send the audio, replaced if it still looks ready
+	urgent bool                    // the connection is replaced right away if it's not ready
+}
+
+// CheckVoice asks to check the voice connection of the server now.
+// With `urgent` a connection not ready is replaced right away, ex. after the gateway reconnected.
+// Otherwise it has some more time to get ready, ex. when the voice server changed and discordgo is connecting to the new one
+func (v *VoiceInstance) CheckVoice(urgent bool) {
+	v.requestVoiceCheck(voiceCheck{urgent: urgent})
+}
+
+func (v *VoiceInstance) requestVoiceCheck(check voiceCheck) {
+	if v.voiceChecks == nil {
+		return
+	}
+	select {
+	case v.voiceChecks <- check:
+	default: // enough checks already waiting
+	}
+}
+
+// monitorVoice checks the voice connection of the session until the bot disconnects.
+// A connection that stays not ready for too long, or that can't send the audio, is replaced by joining the channel again
+func (v *VoiceInstance) monitorVoice(s discord.Session, guildId string, checks chan voiceCheck) {
+	ticker := time.NewTicker(time.Duration(models.VoiceCheckSeconds) * time.Second)
+	defer ticker.Stop()
+
+	recovery := time.Duration(models.VoiceRecoverySeconds) * time.Second
+	var unready time.Time // when the connection stopped being ready, zero while it's ready
+
+	for {
+		var check voiceCheck
+		event := false
+		select {
+		case <-ticker.C:
+		case check = <-checks:
+			event = true
+		}
+
+		var connection discord.VoiceConnection
+		v.do(func() {
+			if v.voiceChecks == checks {
+				connection = v.Connection
+			}
+		})
+		if connection == nil { // disconnected, or another session started
+			return
+		}
+
+		ready := connection.Ready()
+		switch {
+		case check.failed != nil && check.failed != connection:
+			continue // already replaced
+		case check.failed != nil && ready:
+			log.Println("Voice connection ready but not sending the audio")
+		case ready:
+			unready = time.Time{}
+			continue
+		case check.urgent:
+			log.Println("Voice connection not ready after the gateway reconnected")
+		case unready.IsZero() || (event && check.failed == nil):
+			unready = time.Now() // give it some time from now
+			continue
+		case time.Since(unready) < recovery:
+			continue
+		default:
+			log.Println("Voice connection not ready for " + time.Since(unready).Round(time.Second).String())
+		}
+
+		if !v.rejoin(s, guildId, connection, checks) {
+			return
+		}
+		unready = time.Time{}
+	}
+}
+
+// rejoin joins the voice channel again in place of the `old` connection, and the song playing continues on the new one.
+// If it fails the session ends, and the text channel of the session is told why.
+// It returns false if the session ended
+func (v *VoiceInstance) rejoin(s discord.Session, guildId string, old discord.VoiceConnection, checks chan voiceCheck) bool {
+	var channelId string
+	var backoff time.Duration
+	var sessionEnd chan struct{}
+	v.do(func() {
+		if v.Connection != old || v.voiceChecks != checks {
+			return
+		}
+		channelId = v.ChannelId
+		backoff = v.rejoinBackoff
+		sessionEnd = v.sessionEnd
+		v.reconnecting = true // leaving the channel now is not a kick
+	})
+	if channelId == "" {
+		return true // nothing to replace, the next check will see what happened
+	}
+
+	log.Println("Joining the voice channel again")
+	old.Disconnect()
+
+	for attempt := 1; ; attempt++ {
+		connection, err := s.JoinVoice(guildId, channelId)
+		if err == nil {
+			replaced := false
+			v.do(func() {
+				v.reconnecting = false
+				if v.Connection != old || v.voiceChecks != checks { // disconnected in the meantime
+					if v.Connection == nil {
+						connection.Disconnect()
+					}
+					return
+				}
+
+				replaced = true
+				v.Connection = connection
+				if v.Stream != nil {
+					v.Stream.Stop() // the player continues the song on the new connection
+				}
+			})
+			if replaced {
+				log.Println("Voice connection recovered")
+			}
+			return replaced
+		}
+
+		log.Println("ERR: internal/commands/voiceConnection.go: Error joining the voice channel again - ", err)
+		if attempt == models.VoiceRejoinAttempts {
+			break
+		}
+		wait := time.NewTimer(backoff)
+		select {
+		case <-wait.C:
+		case <-sessionEnd: // disconnected while waiting
+			wait.Stop()
+			return false
+		}
+		backoff *= 2
+	}
+
+	v.do(func() {
+		v.reconnecting = false
+		if v.Connection != old || v.voiceChecks != checks {
+			return
+		}
+
+		v.skip()
+		v.disconnect()
+		SendSimpleMessageToChannel(s, v.TextChannelId, "Lost the connection to the voice channel and couldn't join it again", models.ColorError)
+	})
+	return false
+}
+
+// awaitVoice waits until the voice connection is ready to send the audio.
+// It returns false if the song was skipped, the bot disconnected, or the connection didn't get ready in time
+func (v *VoiceInstance) awaitVoice() bool {
+	deadline := time.Now().Add(time.Duration(models.VoiceWaitSeconds) * time.Second)
+
+	for {
+		var connection discord.VoiceConnection
+		stopped := false
+		v.do(func() {
+			connection = v.Connection
+			stopped = v.interrupted || connection == nil
+		})
+
+		if stopped {
+			return false
+		}
+		if connection.Ready() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			log.Println("ERR: internal/commands/voiceConnection.go: Voice connection not ready after " + time.Duration(models.VoiceWaitSeconds*int64(time.Second)).String() + ", dropping the song")
+			return false
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/discord/fake"
+)
+
+// playOnVoice plays the first song and waits for it to be sent to the voice connection, which it returns
+func playOnVoice(t *testing.T) (*fake.Session, *ServerInstance, *fake.VoiceConnection) {
+	t.Helper()
+
+	s, instance := newTestServer(t, 5000, "user")
+	i := slashCommand("play", "user", stringOption("input", "https://youtu.be/"+testVideos[0].ID))
+	runCommand(s, instance, i, testConfig())
+	response(t, s, i)
+	nowPlaying(t, s, "First song")
+	sending(t, s)
+	return s, instance, s.Connections()[0]
+}
+
+// waitRejoined waits until the bot joined the channel again, and the song continues on the new connection
+func waitRejoined(t *testing.T, s *fake.Session, instance *ServerInstance) *fake.VoiceConnection {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if connections := s.Connections(); len(connections) == 2 && connections[1].Frames() > 0 {
+			var connection discord.VoiceConnection
+			instance.Do(func() {
+				connection = instance.Voice.Connection
+			})
+			if connection != connections[1] {
+				t.Fatal("the new connection is not the one of the server")
+			}
+			return connections[1]
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%d voice connections after 5s, want the song playing on the second one", len(s.Connections()))
+	return nil
+}
+
+func TestVoiceRejoin(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(instance *ServerInstance, connection *fake.VoiceConnection)
+	}{
+		{"not ready after the gateway reconnected", func(instance *ServerInstance, connection *fake.VoiceConnection) {
+			connection.SetReady(false)
+			instance.Voice.CheckVoice(true)
+		}},
+		{"ready but not sending the audio", func(instance *ServerInstance, connection *fake.VoiceConnection) {
+			instance.Do(func() {
+				instance.Voice.requestVoiceCheck(voiceCheck{failed: connection})
+			})
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, instance, connection := playOnVoice(t)
+			test.check(instance, connection)
+
+			waitRejoined(t, s, instance)
+			if !connection.IsDisconnected() {
+				t.Fatal("the old connection is still open")
+			}
+		})
+	}
+}
+
+// TestVoiceNotReady gives some time to a connection not ready after the voice server changed, instead of replacing it
+func TestVoiceNotReady(t *testing.T) {
+	s, instance, connection := playOnVoice(t)
+
+	connection.SetReady(false)
+	instance.Voice.CheckVoice(false)
+	time.Sleep(100 * time.Millisecond)
+	connection.SetReady(true)
+	instance.Voice.CheckVoice(false)
+	time.Sleep(100 * time.Millisecond)
+
+	if len(s.Connections()) != 1 || connection.IsDisconnected() {
+		t.Fatalf("connection replaced while getting ready, %d connections", len(s.Connections()))
+	}
+}
+
+func TestVoiceRejoinFails(t *testing.T) {
+	s, instance, connection := playOnVoice(t)
+	instance.Do(func() {
+		instance.Voice.rejoinBackoff = time.Millisecond
+	})
+
+	s.SetJoinError(errors.New("voice server down"))
+	connection.SetReady(false)
+	instance.Voice.CheckVoice(true)
+
+	waitFor(t, s, "message of the connection lost", func(message fake.Message) bool {
+		return message.Embeds[0].Description == "Lost the connection to the voice channel and couldn't join it again"
+	})
+	instance.Do(func() {
+		if instance.Voice.Connection != nil || instance.Voice.voiceChecks != nil {
+			t.Error("session not ended after failing to join the channel again")
+		}
+	})
+}
+
+// TestVoiceRejoinDisconnected disconnects the bot while it waits to join the channel again: it stops waiting
+func TestVoiceRejoinDisconnected(t *testing.T) {
+	s, instance, connection := playOnVoice(t)
+
+	var checks chan voiceCheck
+	instance.Do(func() {
+		instance.Voice.rejoinBackoff = time.Hour
+		checks = instance.Voice.voiceChecks
+	})
+	s.SetJoinError(errors.New("voice server down"))
+
+	rejoined := make(chan bool)
+	go func() {
+		rejoined <- instance.Voice.rejoin(s, testGuild, connection, checks)
+	}()
+
+	// the old connection is closed before the first attempt
+	deadline := time.Now().Add(5 * time.Second)
+	for !connection.IsDisconnected() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	instance.Do(func() {
+		instance.Voice.skip()
+		instance.Voice.disconnect()
+	})
+
+	select {
+	case ok := <-rejoined:
+		if ok {
+			t.Fatal("rejoined after the disconnection")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still waiting to join the channel again 1s after the disconnection")
+	}
+}
@@ -1,33 +1,71 @@
 package commands
 
 import (
-	"bufio"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
-	"os"
-	"os/exec"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/bwmarrin/discordgo"
-	"github.com/matthew-balzan/dca"
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/discord"
 	"github.com/matthew-balzan/eido/internal/models"
 )
 
 type ServerInstance struct {
 	ServerId string
 	Voice    *VoiceInstance
+	actions  chan func()
 }
 
 type VoiceInstance struct {
-	ChannelId  string
-	Connection *discordgo.VoiceConnection
-	Encoder    *dca.EncodeSession
-	Stream     *dca.StreamingSession
-	IsPlaying  bool
-	Queue      chan Song
-	QueueList  []Song //Copy of the channel, needed to show queue to the user
-	Timer      *time.Timer
+	ChannelId     string
+	TextChannelId string // channel where the session was started, used for notifications
+	Connection    discord.VoiceConnection
+	Pipeline      *audio.Pipeline // how the songs are downloaded and encoded
+	Stream        *audio.Sender
+	IsPlaying     bool
+	Queue         chan Song
+	QueueList     []Song //Copy of the channel, needed to show queue to the user
+	History       []Song // Songs played, used by autoplay
+	Autoplay      bool
+	FairQueue     bool            // rotate between requesters instead of first-in first-out
+	Profile       string          // encoding profile chosen for the server, empty for the one of the config
+	SkipVotes     map[string]bool // users that voted to skip the current song
+
+	IdleTimeoutMinutes int         // time idle or paused before leaving, 0 for the one of the config
+	idle               idleManager // leaves the channel when idle or paused for too long
+
+	EmptyTimer     *time.Timer // started when everyone leaves the voice channel
+	PausedForEmpty bool        // true if the song was paused because everyone left
+
+	Prefetch      *audio.Stream   // next song, downloaded while the current one plays
+	prefetchAfter *audio.Stream   // stream playing when the next song was prefetched, whose end it mixes
+	importing     *playlistImport // playlist being added in the background
+	current       *audio.Stream   // song playing
+	songStart     time.Duration   // position in the song where the sender playing it started
+	saved         *SavedSession   // session saved at the last shutdown, resumed at startup
+	configs       *models.Config
+
+	interrupted  bool          // true if the song playing was skipped, so it's not opened again when its stream ends
+	interrupts   chan struct{} // closed when the song playing is skipped or the bot disconnects, to stop waiting to retry it
+	retryBackoff time.Duration // wait before opening again a stream that ended early, doubled at each retry
+
+	voiceChecks   chan voiceCheck // requests to the monitor of the voice connection, nil without a session
+	sessionEnd    chan struct{}   // closed when the bot disconnects, to stop waiting to join the channel again
+	reconnecting  bool            // true while the bot leaves and joins the channel again
+	rejoinBackoff time.Duration   // wait before joining the channel again after a failed attempt, doubled at each one
+
+	channelBitrate int                 // bitrate of the voice channel in bps, used by the profiles that follow it
+	memory         *audio.MemoryBudget // memory of the songs of the server
+
+	lookup youtubeLookup // reads youtube, the shared client if nil
+
+	do      func(action func()) // runs the action on the loop of the server
+	joining sync.Mutex          // held while a command joins the voice channel, outside the loop of the server
 }
 
 type VideoInfo struct {
@@ -41,12 +79,29 @@ type VideoInfo struct {
 type Song struct {
 	videoInfo VideoInfo
 	url       string
+	autoplay  bool          // true if the song was added by autoplay
+	start     time.Duration // where the song starts, set when it was interrupted by a restart
+
+	requesterId   string
+	requesterName string
+}
+
+// requestedBy returns who added the song, for display
+func (song Song) requestedBy() string {
+	if song.autoplay {
+		return "Autoplay"
+	}
+	return song.requesterName
 }
 
 func CreateServerInstance(id string) (i *ServerInstance) {
 	i = new(ServerInstance)
 	i.ServerId = id
 	i.Voice = CreateVoiceInstance()
+	i.Voice.do = i.Do
+	i.Voice.idle.do = i.Do
+	i.actions = make(chan func())
+	go i.loop()
 	return i
 }
 
@@ -54,136 +109,370 @@ func CreateVoiceInstance() (i *VoiceInstance) {
 	i = new(VoiceInstance)
 	i.ChannelId = ""
 	i.Connection = nil
-	i.Encoder = nil
+	i.Pipeline = defaultPipeline()
 	i.IsPlaying = false
-	i.Timer = nil
 	i.Queue = nil
 	i.QueueList = make([]Song, 0, models.MaxQueueLength)
+	i.History = make([]Song, 0, models.MaxHistoryLength)
+	i.Autoplay = false
+	i.FairQueue = false
+	i.SkipVotes = map[string]bool{}
+	i.retryBackoff = time.Duration(models.StreamRetryBackoffSeconds) * time.Second
+	i.rejoinBackoff = time.Duration(models.VoiceRejoinBackoffSeconds) * time.Second
+	i.memory = audio.NewMemoryBudget(int64(models.DefaultGuildMemoryLimitMB)<<20, globalMemory)
 	return i
 }
 
-func (v *VoiceInstance) PlaySingleSong(url string) {
-	options := dca.StdEncodeOptions
-	options.RawOutput = true
-	options.Bitrate = 96
-	options.Application = "lowdelay"
-	options.AudioFilter = "volume=0.1"
-	options.BufferedFrames = 1024 * 1024 * 4
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// Do runs the action on the loop of the server and waits for it to finish.
+// Every change to the state of the server goes through here, so they never run concurrently.
+// It must not be called from inside another action, or it will wait forever
+func (i *ServerInstance) Do(action func()) {
+	done := make(chan struct{})
+	i.actions <- func() {
+		defer close(done)
+		action()
+	}
+	<-done
+}
 
-	cmd := exec.CommandContext(ctx, "yt-dlp", "-f", "best*[vcodec=none][acodec=opus]", "-o", "-", "--download-sections", "*from-url", url)
-	defer cmd.Wait()
+// DoContext runs the action on the loop of the server like Do, but gives up when the context ends.
+// It returns false if it gave up: the action may still run later, so it must not write what the caller reads after
+func (i *ServerInstance) DoContext(ctx context.Context, action func()) bool {
+	done := make(chan struct{})
+	select {
+	case i.actions <- func() {
+		defer close(done)
+		action()
+	}:
+	case <-ctx.Done():
+		return false
+	}
 
-	stdout, err := cmd.StdoutPipe()
-	cmd.Stderr = os.Stderr
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-	if err != nil {
-		log.Println("ERR: internal/models/instance.go: Error calling os exec yt-dlp - ", err)
+func (i *ServerInstance) loop() {
+	for action := range i.actions {
+		i.run(action)
 	}
-	if err := cmd.Start(); err != nil {
-		log.Println("ERR: internal/models/instance.go: Error starting the yt-dlp command - ", err)
+}
+
+func (i *ServerInstance) run(action func()) {
+	// Catch panic error
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("Panic recovered", r)
+		}
+	}()
+
+	action()
+}
+
+// PlaySingleSong plays the song and waits for it to end. It runs outside the loop of the server.
+// If the stream ends before the song, because yt-dlp died or the url of the audio expired,
+// it's opened again from where it stopped. After too many failures the song is skipped
+func (v *VoiceInstance) PlaySingleSong(s discord.Messenger, song Song) {
+	duration, known := parseSongDuration(song.videoInfo.Duration)
+
+	position := song.start
+	paused := false
+
+	var backoff time.Duration
+	var interrupts chan struct{}
+	v.do(func() {
+		backoff = v.retryBackoff
+		interrupts = v.interrupts
+	})
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			wait := time.NewTimer(backoff)
+			select {
+			case <-wait.C:
+			case <-interrupts: // skipped or disconnected while waiting
+				wait.Stop()
+				return
+			}
+			backoff *= 2
+		}
+
+		played, stopped, err := v.playFrom(song, position, &paused)
+		position += played
+		if stopped || !streamEndedEarly(err, position, duration, known) {
+			return
+		}
+
+		if attempt == models.StreamRetries {
+			log.Println("ERR: internal/commands/voiceInstance.go: Stream of "+song.url+" ended at "+formatDuration(position)+" too many times, skipping it - ", err)
+
+			var channel string
+			v.do(func() {
+				channel = v.TextChannelId
+			})
+			SendSimpleMessageToChannel(s, channel, "Couldn't play *"+song.videoInfo.Title+"* after "+strconv.Itoa(models.StreamRetries)+" retries, skipping it", models.ColorError)
+			return
+		}
+
+		log.Println("Stream of " + song.url + " ended at " + formatDuration(position) + ", retrying - " + fmt.Sprint(err))
 	}
+}
+
+// playFrom plays the song from the `start` position and waits for the stream to end.
+// If the voice connection is lost, the song waits for it to be recovered and continues where it was.
+// It returns how much was played, and true in `stopped` if the song was skipped or the bot disconnected.
+// `paused` is kept between the attempts, so a song paused stays paused when opened again
+func (v *VoiceInstance) playFrom(song Song, start time.Duration, paused *bool) (played time.Duration, stopped bool, err error) {
+	var stream *audio.Stream
+	var configs *models.Config
+	var profile models.EncodingProfile
+	v.do(func() {
+		stopped = v.interrupted || v.Connection == nil
+		if stopped {
+			return
+		}
+		if start == 0 {
+			stream = v.takePrefetch(song.url)
+		}
+		configs = v.configs
+		profile = v.encodingProfile(configs)
+	})
 
-	buf := bufio.NewReaderSize(stdout, 8*1024*1024)
+	if stopped {
+		return 0, true, nil
+	}
 
-	encodingSession, err := dca.EncodeMem(buf, options)
-	if err != nil {
-		log.Println("ERR: internal/models/instance.go: Error encoding - ", err)
-		return
+	if stream == nil {
+		stream, err = v.openAudioStream(song, start, nil, profile, configs)
+		if err != nil {
+			return 0, false, err
+		}
 	}
-	defer encodingSession.Cleanup()
+	defer stream.Close()
 
-	v.Encoder = encodingSession
+	// when the bot joins the channel again, the stream continues on the new connection
+	for {
+		if !v.awaitVoice() {
+			return played, true, nil
+		}
+
+		done := make(chan error)
+		var sender *audio.Sender
+		var connection discord.VoiceConnection
 
-	done := make(chan error)
+		v.do(func() {
+			if v.Connection == nil || v.interrupted { // disconnected or skipped in the meantime
+				return
+			}
 
-	v.Connection.Speaking(true)
+			v.current = stream
+			v.songStart = start + played
+			connection = v.Connection
 
-	var stream = dca.NewStream(encodingSession, v.Connection, done)
-	v.Stream = stream
-	errDone := <-done
-	cancel()
+			connection.Speaking(true)
 
-	v.Encoder = nil
-	v.Stream = nil
+			sender = audio.NewSender(stream, connection, done)
+			sender.SetPaused(*paused)
+			v.Stream = sender
 
-	v.Connection.Speaking(false)
+			// start preparing the next song while this one plays
+			v.refreshPrefetch()
+		})
 
-	if errDone != nil && errDone != io.EOF {
-		log.Println("ERR: internal/models/instance.go: Error while playing - ", errDone)
-		return
+		if sender == nil {
+			return played, true, nil
+		}
+
+		err = <-done
+		lost := errors.Is(err, audio.ErrVoiceConnectionClosed)
+
+		v.do(func() {
+			v.current = nil
+			v.Stream = nil
+
+			if v.Connection != nil && v.Connection == connection {
+				v.Connection.Speaking(false)
+			}
+			stopped = v.interrupted || v.Connection == nil
+
+			if lost && !stopped {
+				v.requestVoiceCheck(voiceCheck{failed: connection})
+			}
+		})
+
+		*paused = sender.Paused()
+		played += sender.PlaybackPosition()
+		if err == io.EOF {
+			played += stream.Left() // played mixed with the next song
+		}
+
+		if stopped || !(lost || errors.Is(err, audio.ErrSenderStopped)) {
+			return played, stopped, err
+		}
+
+		log.Println("Voice connection interrupted, continuing " + song.url + " from " + formatDuration(start+played))
 	}
 }
 
-func (v *VoiceInstance) StopTimer() {
-	if v.Timer != nil {
-		v.Timer.Stop()
+// streamEndedEarly returns true if the stream stopped before the end of the song: with an error, or before its duration.
+// The errors of the voice connection don't count, the stream was fine
+func streamEndedEarly(err error, position time.Duration, duration time.Duration, known bool) bool {
+	if errors.Is(err, audio.ErrVoiceConnectionClosed) {
+		return false
+	}
+	if err != nil && err != io.EOF {
+		return true
 	}
+	return known && position < duration-time.Duration(models.StreamEndToleranceSeconds)*time.Second
 }
 
-func (v *VoiceInstance) StartTimer(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	v.Timer = time.NewTimer(time.Duration(models.TimeoutSecondsDisconnect) * time.Second)
+// sessions are the goroutines playing the queues and adding playlists, waited for at shutdown
+var sessions sync.WaitGroup
 
+// WaitSessions waits for the sessions to end after they were stopped, or for the context to end
+func WaitSessions(ctx context.Context) error {
+	ended := make(chan struct{})
 	go func() {
-		<-v.Timer.C // signal to disconnect
-
-		log.Println("Bot disconnected for inactivity")
-		v.disconnect()
-		SendSimpleMessage(s, i, "Disconnected for inactivity", models.ColorDefault)
+		sessions.Wait()
+		close(ended)
 	}()
-}
 
-func (v *VoiceInstance) startAudioSession(s *discordgo.Session, i *discordgo.InteractionCreate, voiceChannel string) {
-	v.Queue = make(chan Song, models.MaxQueueLength)
+	select {
+	case <-ended:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	var err error = nil
-	var voiceConnection *discordgo.VoiceConnection = nil
-	voiceConnection, err = s.ChannelVoiceJoin(i.GuildID, voiceChannel, false, true)
+// joinSession joins the voice channel and starts a new session, if the bot is not in a voice channel already.
+// It runs outside the loop of the server, which is not blocked while joining.
+// It returns false if the bot couldn't join the channel
+func (v *VoiceInstance) joinSession(s discord.Session, guildId string, voiceChannel string, textChannel string, configs *models.Config) bool {
+	// two commands joining at the same time would move the bot between channels
+	v.joining.Lock()
+	defer v.joining.Unlock()
+
+	connected := false
+	v.do(func() {
+		connected = v.Connection != nil
+	})
+	if connected {
+		return true
+	}
 
+	voiceConnection, err := s.JoinVoice(guildId, voiceChannel)
 	if err != nil {
 		log.Println("ERR: internal/commands/audio.go: Error joining voice channel - ", err)
-		return
+		return false
 	}
 
+	v.do(func() {
+		v.startAudioSession(s, guildId, voiceChannel, textChannel, voiceConnection, configs)
+	})
+	return true
+}
+
+// startAudioSession starts playing the queue on the voice connection just joined.
+// The messages about the songs are sent to `textChannel`
+func (v *VoiceInstance) startAudioSession(s discord.Session, guildId string, voiceChannel string, textChannel string, voiceConnection discord.VoiceConnection, configs *models.Config) {
+	queue := make(chan Song, models.MaxQueueLength)
+
+	v.Queue = queue
 	v.ChannelId = voiceChannel
+	v.TextChannelId = textChannel
 	v.Connection = voiceConnection
+	v.configs = configs
+	v.channelBitrate = s.ChannelBitrate(guildId, voiceChannel)
+
+	checks := make(chan voiceCheck, 4)
+	v.voiceChecks = checks
+	v.sessionEnd = make(chan struct{})
+	go v.monitorVoice(s, guildId, checks)
+
+	v.idle.expired = func(state IdleState) {
+		v.onIdleExpired(s, state)
+	}
+	v.setIdle(IdleWaiting) // in case the first song will not be added because of an error
 
+	sessions.Add(1)
 	go func() {
-		v.StartTimer(s, i) // in case the first song will not be added because of an error
-		for song := range v.Queue {
-			v.StopTimer()
-			if v.Connection == nil {
+		defer sessions.Done()
+
+		for song := range queue {
+			var connection discord.VoiceConnection
+
+			v.do(func() {
+				connection = v.Connection
+				if connection == nil {
+					return
+				}
+
+				v.IsPlaying = true
+				v.setIdle(IdlePlaying)
+				v.interrupted = false
+				v.interrupts = make(chan struct{})
+				v.SkipVotes = map[string]bool{}
+				v.addToHistory(song)
+			})
+
+			if connection == nil {
 				return
 			}
 
-			v.IsPlaying = true
+			author := "Now playing:"
+			if song.autoplay {
+				author = "Now playing (autoplay):"
+			}
 
-			SendComplexMessage(
+			SendComplexMessageToChannel(
 				s,
-				i,
+				textChannel,
 				song.videoInfo.Title,
 				song.url,
 				song.videoInfo.Thumbnail,
-				song.videoInfo.Duration,
+				song.videoInfo.Duration+" | Requested by "+song.requestedBy(),
 				models.ColorDefault,
-				"Now playing:",
+				author,
 			)
 
-			for i := 0; !v.Connection.Ready && i < 6; i++ { // retry 6 times, which is equals to 30 seconds
-				time.Sleep(5 * time.Second)
+			v.PlaySingleSong(s, song)
+
+			var autoplay bool
+			var history []Song
+
+			v.do(func() {
+				if len(v.QueueList) > 0 { // in case a clear has happened
+					v.QueueList = v.QueueList[1:] // dequeue
+				}
+				v.IsPlaying = false
+
+				autoplay = len(v.Queue) == 0 && v.Autoplay && v.Connection != nil
+				if autoplay {
+					history = append([]Song(nil), v.History...)
+				}
+			})
+
+			if autoplay {
+				if next, ok := nextAutoplaySong(history, configs); ok {
+					v.do(func() {
+						v.addToQueue(next)
+					})
+					continue
+				}
+				SendSimpleMessageToChannel(s, textChannel, "Autoplay couldn't find a song to play", models.ColorError)
 			}
 
-			v.PlaySingleSong(song.url)
-
-			if len(v.QueueList) > 0 { // in case a clear has happened
-				v.QueueList = v.QueueList[1:] // dequeue
-			}
-			v.IsPlaying = false
-			v.StartTimer(s, i)
+			v.do(func() {
+				if v.Connection != nil && len(v.Queue) == 0 {
+					v.setIdle(IdleWaiting)
+				}
+			})
 		}
-
 	}()
 }
 
@@ -199,20 +488,44 @@ func (v *VoiceInstance) addToQueue(song Song) (res bool) {
 
 	v.Queue <- song
 	v.QueueList = append(v.QueueList, song)
+
+	if v.FairQueue {
+		v.reorderQueue()
+	}
+	v.refreshPrefetch()
 	return true
 }
 
 func (v *VoiceInstance) skip() {
-	if v.Encoder != nil {
-		v.Encoder.Cleanup()
+	v.interrupted = true
+	v.wakePlayer()
+	if v.current != nil {
+		// ffmpeg can take a while to stop, the player will notice when the stream ends
+		go v.current.Close()
 	}
 	v.setPause(false)
 }
 
+// playbackPosition returns the position in the song playing
+func (v *VoiceInstance) playbackPosition() time.Duration {
+	if v.Stream == nil {
+		return v.songStart
+	}
+	return v.songStart + v.Stream.PlaybackPosition()
+}
+
 func (v *VoiceInstance) setPause(pause bool) {
 	if v.Stream != nil {
 		v.Stream.SetPaused(pause)
 	}
+	if !v.IsPlaying {
+		return
+	}
+	if pause {
+		v.setIdle(IdlePaused)
+	} else {
+		v.setIdle(IdlePlaying)
+	}
 }
 
 func (v *VoiceInstance) disconnect() {
@@ -222,20 +535,65 @@ func (v *VoiceInstance) disconnect() {
 	v.Connection = nil
 	v.ChannelId = ""
 	v.Stream = nil
-	v.Timer = nil
+	v.voiceChecks = nil
+	if v.sessionEnd != nil {
+		close(v.sessionEnd)
+		v.sessionEnd = nil
+	}
+	v.reconnecting = false
+	v.idle.set(IdleOff, 0)
+	v.stopEmptyTimer()
+	v.PausedForEmpty = false
+	v.cancelPrefetch()
+	v.cancelImport()
+	v.wakePlayer()
 	if v.Queue != nil {
 		close(v.Queue)
+		v.Queue = nil
 	}
 }
 
-func (v *VoiceInstance) clearQueue() {
-	for len(v.Queue) > 0 {
-		<-v.Queue
+// wakePlayer stops the player from waiting to retry the song playing
+func (v *VoiceInstance) wakePlayer() {
+	if v.interrupts == nil {
+		return
+	}
+	select {
+	case <-v.interrupts: // already woken up
+	default:
+		close(v.interrupts)
+	}
+}
+
+// drainQueue takes the songs still waiting in the queue channel.
+// The player takes songs from the channel at any time, so it never waits for a song that the player may have just taken
+func (v *VoiceInstance) drainQueue() (waiting []Song) {
+	for {
+		select {
+		case song := <-v.Queue:
+			waiting = append(waiting, song)
+		default:
+			return waiting
+		}
 	}
+}
+
+func (v *VoiceInstance) clearQueue() {
+	v.drainQueue()
 	v.QueueList = make([]Song, 0, models.MaxQueueLength)
+	v.cancelPrefetch()
+	v.cancelImport()
 	v.skip()
 }
 
+// cancelImport stops adding the playlist being imported, if any
+func (v *VoiceInstance) cancelImport() {
+	if v.importing != nil {
+		v.importing.cancel()
+		v.importing = nil
+	}
+}
+
 func (v *VoiceInstance) getQueueList() (queue []Song) {
 	return v.QueueList
 }
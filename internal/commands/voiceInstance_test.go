@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/matthew-balzan/eido/internal/audio"
+	"github.com/matthew-balzan/eido/internal/discord/fake"
+	"github.com/matthew-balzan/eido/internal/youtube"
+)
+
+func TestStreamEndedEarly(t *testing.T) {
+	song := 3 * time.Minute
+	died := errors.New("yt-dlp died")
+
+	tests := []struct {
+		name     string
+		err      error
+		position time.Duration
+		known    bool
+		want     bool
+	}{
+		{"end of the song", io.EOF, song, true, false},
+		{"within the tolerance", io.EOF, song - 3*time.Second, true, false},
+		{"before the end", io.EOF, time.Minute, true, true},
+		{"duration unknown", io.EOF, time.Minute, false, false},
+		{"error of the stream", died, song, true, true},
+		{"error with duration unknown", died, time.Minute, false, true},
+		{"voice connection lost", audio.ErrVoiceConnectionClosed, time.Minute, true, false},
+		{"voice connection lost, wrapped", errors.Join(died, audio.ErrVoiceConnectionClosed), time.Minute, true, false},
+		{"no error", nil, time.Minute, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := streamEndedEarly(test.err, test.position, song, test.known); got != test.want {
+				t.Fatalf("ended early %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+// shortSong lasts 10 seconds: a stream that ends before 5 seconds ended early
+var shortSong = youtube.Video{ID: "eeeeeeeeeee", Title: "Short song", Author: "Someone", Duration: "0:10"}
+
+// scriptedSource returns the streams of `open` in order, one for each time it's opened, and counts them
+type scriptedSource struct {
+	opened  atomic.Int64
+	streams []func() io.Reader
+}
+
+func (s *scriptedSource) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	n := int(s.opened.Add(1)) - 1
+	return io.NopCloser(s.streams[min(n, len(s.streams)-1)]()), nil
+}
+
+// frames returns a stream of frames of the test pipeline, sent without waiting
+func frames(n int) func() io.Reader {
+	return func() io.Reader {
+		return bytes.NewReader(make([]byte, n*10))
+	}
+}
+
+// failing returns a stream that fails after `n` frames
+func failing(n int) func() io.Reader {
+	return func() io.Reader {
+		return io.MultiReader(frames(n)(), &errorReader{errors.New("yt-dlp died")})
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	return 0, e.err
+}
+
+// playShortSong plays the short song with the source, and waits for the player to start it
+func playShortSong(t *testing.T, source *scriptedSource, backoff time.Duration) (*fake.Session, *ServerInstance) {
+	t.Helper()
+
+	s, instance := newTestServer(t, 0, "user")
+	instance.Voice.Pipeline.Source = source
+	instance.Voice.lookup = &fakeLookup{videos: []youtube.Video{shortSong}}
+	instance.Voice.retryBackoff = backoff
+
+	i := slashCommand("play", "user", stringOption("input", "https://youtu.be/"+shortSong.ID))
+	runCommand(s, instance, i, testConfig())
+	response(t, s, i)
+	nowPlaying(t, s, shortSong.Title)
+	return s, instance
+}
+
+// waitStopped waits until the player is not playing anymore
+func waitStopped(t *testing.T, s *fake.Session, instance *ServerInstance) {
+	t.Helper()
+	waitFor(t, s, "end of the song", func(fake.Message) bool {
+		playing := true
+		instance.Do(func() {
+			playing = instance.Voice.IsPlaying
+		})
+		return !playing
+	})
+}
+
+func TestStreamRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		streams []func() io.Reader
+		opened  int64
+		skipped bool
+	}{
+		{"complete", []func() io.Reader{frames(400)}, 1, false},
+		{"recovers after an error", []func() io.Reader{failing(20), frames(400)}, 2, false},
+		{"recovers after ending early", []func() io.Reader{frames(20), frames(20), frames(400)}, 3, false},
+		{"skipped after too many retries", []func() io.Reader{frames(20)}, 4, true},
+		{"skipped after too many errors", []func() io.Reader{failing(20)}, 4, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := &scriptedSource{streams: test.streams}
+			s, instance := playShortSong(t, source, time.Millisecond)
+			waitStopped(t, s, instance)
+
+			if opened := source.opened.Load(); opened != test.opened {
+				t.Fatalf("stream opened %d times, want %d", opened, test.opened)
+			}
+
+			skipped := false
+			for _, text := range messageTexts(s) {
+				if text == "Couldn't play *Short song* after 3 retries, skipping it" {
+					skipped = true
+				}
+			}
+			if skipped != test.skipped {
+				t.Fatalf("skipped %t, want %t, messages: %v", skipped, test.skipped, messageTexts(s))
+			}
+		})
+	}
+}
+
+// TestStreamRetrySkipped skips the song while the player waits to open it again: it moves on right away
+func TestStreamRetrySkipped(t *testing.T) {
+	source := &scriptedSource{streams: []func() io.Reader{frames(20)}}
+	s, instance := playShortSong(t, source, time.Hour)
+
+	// the first stream ends early, and the player waits an hour to retry
+	waitFor(t, s, "end of the first stream", func(fake.Message) bool {
+		if connections := s.Connections(); len(connections) == 0 || connections[0].Frames() < 20 {
+			return false
+		}
+		waiting := false
+		instance.Do(func() {
+			waiting = instance.Voice.IsPlaying && instance.Voice.current == nil
+		})
+		return waiting
+	})
+
+	start := time.Now()
+	instance.Do(func() {
+		instance.Voice.skip()
+	})
+	waitStopped(t, s, instance)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the player moved on %s after the skip", elapsed)
+	}
+	if opened := source.opened.Load(); opened != 1 {
+		t.Fatalf("stream opened %d times after the skip, want once", opened)
+	}
+}
@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"log"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
+)
+
+// HandleVoiceStateUpdate keeps the voice instance in sync with its voice channel:
+// it follows the bot when moved, cleans up when the bot is kicked,
+// and pauses the song while nobody is listening
+func HandleVoiceStateUpdate(s discord.Session, vs *discordgo.VoiceStateUpdate, instance *ServerInstance, configs *models.Config) {
+	v := instance.Voice
+
+	if v.Connection == nil {
+		return
+	}
+
+	if vs.UserID == s.BotUserID() {
+		if vs.ChannelID == "" && v.reconnecting { // left to join again
+			return
+		}
+		if vs.ChannelID == "" { // kicked or disconnected by a moderator
+			log.Println("Bot removed from the voice channel")
+			v.skip()
+			v.disconnect()
+			return
+		}
+		if vs.ChannelID != v.ChannelId { // moved to another channel
+			log.Println("Bot moved to another voice channel")
+			v.ChannelId = vs.ChannelID
+			v.channelBitrate = s.ChannelBitrate(vs.GuildID, vs.ChannelID)
+		}
+	} else if vs.ChannelID != v.ChannelId && (vs.BeforeUpdate == nil || vs.BeforeUpdate.ChannelID != v.ChannelId) {
+		return // not about our channel
+	}
+
+	if countListeners(s, vs.GuildID, v.ChannelId) == 0 {
+		v.onChannelEmpty(s, configs)
+	} else {
+		v.onChannelNotEmpty()
+	}
+}
+
+// onChannelEmpty pauses the song and starts the timer to leave the channel
+func (v *VoiceInstance) onChannelEmpty(s discord.Session, configs *models.Config) {
+	if v.IsPlaying && v.Stream != nil && !v.Stream.Paused() {
+		v.setPause(true)
+		v.PausedForEmpty = true
+	}
+
+	if v.EmptyTimer != nil {
+		return // already waiting
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Duration(configs.EmptyChannelTimeoutSeconds)*time.Second, func() {
+		v.do(func() {
+			if v.EmptyTimer != timer { // somebody came back in the meantime
+				return
+			}
+
+			log.Println("Bot disconnected because the voice channel is empty")
+			v.skip()
+			v.disconnect()
+			SendSimpleMessageToChannel(s, v.TextChannelId, "Disconnected because everyone left the voice channel", models.ColorDefault)
+		})
+	})
+	v.EmptyTimer = timer
+}
+
+// onChannelNotEmpty stops the timer to leave the channel and resumes the song, if it was paused because nobody was listening
+func (v *VoiceInstance) onChannelNotEmpty() {
+	v.stopEmptyTimer()
+
+	if v.PausedForEmpty {
+		v.PausedForEmpty = false
+		v.setPause(false)
+	}
+}
+
+func (v *VoiceInstance) stopEmptyTimer() {
+	if v.EmptyTimer != nil {
+		v.EmptyTimer.Stop()
+		v.EmptyTimer = nil
+	}
+}
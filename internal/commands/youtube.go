@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/youtube"
+)
+
+// ytClient reads the metadata of the videos for all the servers. It's opened at startup
+var ytClient *youtube.Client
+
+var errNoYoutubeClient = errors.New("youtube client not opened")
+
+// youtubeLookup reads the metadata of videos, playlists and searches. It's implemented by the youtube client
+type youtubeLookup interface {
+	Video(ctx context.Context, id string) (youtube.Video, error)
+	Playlist(ctx context.Context, id string) (youtube.Playlist, error)
+	// PlaylistRange reads the videos of the playlist from `first` to `last` excluded a page at a time, until `page` returns false
+	PlaylistRange(ctx context.Context, id string, first int, last int, page func(playlist youtube.Playlist, total int) bool) error
+	// Search returns the id of the first video found
+	Search(ctx context.Context, query string) (id string, err error)
+}
+
+// OpenYoutubeClient opens the youtube client shared by all the servers
+func OpenYoutubeClient(configs *models.Config) (err error) {
+	ytClient, err = youtube.NewClient(configs.YoutubeKey, configs.YoutubeQuotaBudget, youtubeCacheTTL(configs))
+	if err != nil {
+		return err
+	}
+	ytClient.Configure(configs.YoutubeQuotaBudget, youtubeCacheTTL(configs), configs.YoutubeRegion)
+	return nil
+}
+
+// youtube returns the lookup of the server: the shared client, unless the instance was given another one
+func (v *VoiceInstance) youtube(configs *models.Config) youtubeLookup {
+	if v.lookup != nil {
+		return v.lookup
+	}
+	return youtubeClient(configs)
+}
+
+// youtubeClient returns the shared client, with the budget and cache time of the config
+func youtubeClient(configs *models.Config) youtubeLookup {
+	if ytClient == nil {
+		return unopenedClient{}
+	}
+	ytClient.Configure(configs.YoutubeQuotaBudget, youtubeCacheTTL(configs), configs.YoutubeRegion)
+	return ytClient
+}
+
+func youtubeCacheTTL(configs *models.Config) time.Duration {
+	return time.Duration(configs.YoutubeCacheTTLMinutes) * time.Minute
+}
+
+// unopenedClient fails every lookup, it's used when the shared client couldn't be opened
+type unopenedClient struct{}
+
+func (unopenedClient) Video(ctx context.Context, id string) (youtube.Video, error) {
+	return youtube.Video{}, errNoYoutubeClient
+}
+
+func (unopenedClient) Playlist(ctx context.Context, id string) (youtube.Playlist, error) {
+	return youtube.Playlist{}, errNoYoutubeClient
+}
+
+func (unopenedClient) PlaylistRange(ctx context.Context, id string, first int, last int, page func(playlist youtube.Playlist, total int) bool) error {
+	return errNoYoutubeClient
+}
+
+func (unopenedClient) Search(ctx context.Context, query string) (string, error) {
+	return "", errNoYoutubeClient
+}
+
+// getVideo returns the metadata of the video
+func getVideo(lookup youtubeLookup, id string) (videoInfo VideoInfo, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	video, err := lookup.Video(ctx, id)
+	return VideoInfo(video), err
+}
+
+// getPlaylistVideos returns the videos of the youtube playlist that can be played, and the ones that can't
+func getPlaylistVideos(lookup youtubeLookup, id string) (list []VideoInfo, unavailable []youtube.Unavailable, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	playlist, err := lookup.Playlist(ctx, id)
+	for _, video := range playlist.Videos {
+		list = append(list, VideoInfo(video))
+	}
+	return list, playlist.Unavailable, err
+}
+
+// searchVideoUrl returns the url of the first video found for the input
+func searchVideoUrl(lookup youtubeLookup, input string) (url string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	id, err := lookup.Search(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return "https://www.youtube.com/watch?v=" + id, nil
+}
+
+// formatYoutubeStats returns the quota of the day and the numbers of the metadata cache
+func formatYoutubeStats(stats youtube.ClientStats) string {
+	quota := stats.Quota
+	message := fmt.Sprintf("%d / %d units (%d%%)", quota.Used, quota.Budget, quota.Used*100/max(quota.Budget, 1))
+	message += fmt.Sprintf(", resets in %s", time.Until(quota.Reset).Round(time.Minute))
+	if quota.Denied > 0 {
+		message += fmt.Sprintf("\n%d calls over the budget, %d made with yt-dlp", quota.Denied, stats.Fallbacks)
+	}
+	message += fmt.Sprintf("\n%d cached, %d cache hits", stats.Cached, stats.CacheHits)
+	return message
+}
@@ -0,0 +1,110 @@
+package discord
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// session implements Session with a discordgo session
+type session struct {
+	*discordgo.Session
+}
+
+func NewSession(s *discordgo.Session) Session {
+	return &session{s}
+}
+
+func (s *session) JoinVoice(guildID string, channelID string) (VoiceConnection, error) {
+	vc, err := s.ChannelVoiceJoin(guildID, channelID, false, true)
+	if err != nil {
+		return nil, err
+	}
+	return &voiceConnection{vc}, nil
+}
+
+func (s *session) BotUserID() string {
+	return s.State.User.ID
+}
+
+func (s *session) UserVoiceChannel(guildID string, userID string) string {
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		return ""
+	}
+
+	s.State.RLock()
+	defer s.State.RUnlock()
+
+	for _, vs := range guild.VoiceStates {
+		if vs.UserID == userID {
+			return vs.ChannelID
+		}
+	}
+	return ""
+}
+
+func (s *session) VoiceChannelUsers(guildID string, channelID string) (users []string) {
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		return users
+	}
+
+	s.State.RLock()
+	defer s.State.RUnlock()
+
+	for _, vs := range guild.VoiceStates {
+		if vs.ChannelID == channelID {
+			users = append(users, vs.UserID)
+		}
+	}
+	return users
+}
+
+func (s *session) IsBot(guildID string, userID string) bool {
+	member, err := s.State.Member(guildID, userID)
+	return err == nil && member.User != nil && member.User.Bot
+}
+
+func (s *session) RoleName(guildID string, roleID string) string {
+	role, err := s.State.Role(guildID, roleID)
+	if err != nil {
+		return ""
+	}
+	return role.Name
+}
+
+func (s *session) ChannelBitrate(guildID string, channelID string) int {
+	channel, err := s.State.Channel(channelID)
+	if err != nil {
+		return 0
+	}
+	return channel.Bitrate
+}
+
+// voiceConnection implements VoiceConnection with a discordgo voice connection
+type voiceConnection struct {
+	vc *discordgo.VoiceConnection
+}
+
+func (c *voiceConnection) ChannelID() string {
+	c.vc.RLock()
+	defer c.vc.RUnlock()
+	return c.vc.ChannelID
+}
+
+func (c *voiceConnection) Ready() bool {
+	c.vc.RLock()
+	defer c.vc.RUnlock()
+	return c.vc.Ready
+}
+
+func (c *voiceConnection) Speaking(speaking bool) error {
+	return c.vc.Speaking(speaking)
+}
+
+func (c *voiceConnection) OpusSend() chan<- []byte {
+	return c.vc.OpusSend
+}
+
+func (c *voiceConnection) Disconnect() error {
+	return c.vc.Disconnect()
+}
@@ -0,0 +1,323 @@
+// Package fake implements the discord interfaces in memory, to run the commands without a network
+package fake
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/matthew-balzan/eido/internal/discord"
+)
+
+// Message is a message sent to a text channel, or a response to an interaction
+type Message struct {
+	ChannelID     string
+	InteractionID string
+	Embeds        []*discordgo.MessageEmbed
+	Components    []discordgo.MessageComponent
+}
+
+// Session records the messages sent and simulates the voice states of the users
+type Session struct {
+	lock sync.Mutex
+
+	botID       string
+	messages    []Message
+	voiceStates map[string]map[string]string // guild -> user -> channel
+	bots        map[string]bool
+	roles       map[string]string // role id -> name
+	bitrates    map[string]int    // channel id -> bitrate
+	connections []*VoiceConnection
+
+	joinError error // returned when joining a voice channel, if set
+}
+
+func NewSession(botID string) *Session {
+	return &Session{
+		botID:       botID,
+		voiceStates: map[string]map[string]string{},
+		bots:        map[string]bool{botID: true},
+		roles:       map[string]string{},
+		bitrates:    map[string]int{},
+	}
+}
+
+// SetVoiceState moves the user to the voice channel. An empty channel removes the user from voice
+func (s *Session) SetVoiceState(guildID string, userID string, channelID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.voiceStates[guildID] == nil {
+		s.voiceStates[guildID] = map[string]string{}
+	}
+	if channelID == "" {
+		delete(s.voiceStates[guildID], userID)
+		return
+	}
+	s.voiceStates[guildID][userID] = channelID
+}
+
+// SetBot marks the user as a bot
+func (s *Session) SetBot(userID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.bots[userID] = true
+}
+
+// SetRole sets the name of the role
+func (s *Session) SetRole(roleID string, name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.roles[roleID] = name
+}
+
+// SetJoinError makes joining a voice channel fail with the error, or succeed again if nil
+func (s *Session) SetJoinError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.joinError = err
+}
+
+// SetChannelBitrate sets the bitrate of the voice channel, in bps
+func (s *Session) SetChannelBitrate(channelID string, bitrate int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.bitrates[channelID] = bitrate
+}
+
+// Messages returns the messages and responses sent so far, in order
+func (s *Session) Messages() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+// Connections returns the voice connections opened so far, in order
+func (s *Session) Connections() []*VoiceConnection {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]*VoiceConnection(nil), s.connections...)
+}
+
+func (s *Session) InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	message := Message{ChannelID: interaction.ChannelID, InteractionID: interaction.ID}
+	if resp.Data != nil {
+		message.Embeds = resp.Data.Embeds
+		message.Components = resp.Data.Components
+	}
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+// InteractionResponseEdit changes the last response to the interaction
+func (s *Session) InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for j := len(s.messages) - 1; j >= 0; j-- {
+		message := &s.messages[j]
+		if message.InteractionID != interaction.ID {
+			continue
+		}
+		if newresp.Embeds != nil {
+			message.Embeds = *newresp.Embeds
+		}
+		if newresp.Components != nil {
+			message.Components = *newresp.Components
+		}
+		return &discordgo.Message{ChannelID: message.ChannelID, Embeds: message.Embeds}, nil
+	}
+	return nil, errors.New("fake: no response to edit")
+}
+
+func (s *Session) ChannelMessageSendEmbeds(channelID string, embeds []*discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.messages = append(s.messages, Message{ChannelID: channelID, Embeds: embeds})
+	return &discordgo.Message{ChannelID: channelID, Embeds: embeds}, nil
+}
+
+func (s *Session) JoinVoice(guildID string, channelID string) (discord.VoiceConnection, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.joinError != nil {
+		return nil, s.joinError
+	}
+
+	if s.voiceStates[guildID] == nil {
+		s.voiceStates[guildID] = map[string]string{}
+	}
+	s.voiceStates[guildID][s.botID] = channelID
+
+	connection := NewVoiceConnection(channelID)
+	s.connections = append(s.connections, connection)
+	return connection, nil
+}
+
+func (s *Session) BotUserID() string {
+	return s.botID
+}
+
+func (s *Session) UserVoiceChannel(guildID string, userID string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.voiceStates[guildID][userID]
+}
+
+func (s *Session) VoiceChannelUsers(guildID string, channelID string) (users []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for user, channel := range s.voiceStates[guildID] {
+		if channel == channelID {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+func (s *Session) IsBot(guildID string, userID string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.bots[userID]
+}
+
+func (s *Session) RoleName(guildID string, roleID string) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.roles[roleID]
+}
+
+func (s *Session) ChannelBitrate(guildID string, channelID string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.bitrates[channelID]
+}
+
+var ErrDisconnected = errors.New("voice connection closed")
+
+// VoiceConnection counts the opus frames it receives instead of sending them
+type VoiceConnection struct {
+	lock sync.Mutex
+
+	channelID    string
+	speaking     bool
+	disconnected bool
+	unready      bool
+	frames       int
+
+	send chan []byte
+}
+
+func NewVoiceConnection(channelID string) *VoiceConnection {
+	c := &VoiceConnection{
+		channelID: channelID,
+		send:      make(chan []byte),
+	}
+	go c.receive()
+	return c
+}
+
+func (c *VoiceConnection) receive() {
+	for range c.send {
+		c.lock.Lock()
+		if !c.disconnected {
+			c.frames++
+		}
+		c.lock.Unlock()
+	}
+}
+
+// Frames returns the number of opus frames received
+func (c *VoiceConnection) Frames() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.frames
+}
+
+// IsSpeaking returns the last speaking state set
+func (c *VoiceConnection) IsSpeaking() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.speaking
+}
+
+// IsDisconnected returns true after Disconnect
+func (c *VoiceConnection) IsDisconnected() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.disconnected
+}
+
+// Move simulates the bot being moved to another channel
+func (c *VoiceConnection) Move(channelID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.channelID = channelID
+}
+
+func (c *VoiceConnection) ChannelID() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.channelID
+}
+
+func (c *VoiceConnection) Ready() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return !c.disconnected && !c.unready
+}
+
+// SetReady simulates the connection to the voice server being lost and recovered
+func (c *VoiceConnection) SetReady(ready bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.unready = !ready
+}
+
+func (c *VoiceConnection) Speaking(speaking bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.disconnected {
+		return ErrDisconnected
+	}
+	c.speaking = speaking
+	return nil
+}
+
+func (c *VoiceConnection) OpusSend() chan<- []byte {
+	return c.send
+}
+
+func (c *VoiceConnection) Disconnect() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.disconnected = true
+	c.speaking = false
+	return nil
+}
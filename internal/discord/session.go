@@ -0,0 +1,56 @@
+package discord
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// Responder answers to interactions
+type Responder interface {
+	InteractionRespond(interaction *discordgo.Interaction, resp *discordgo.InteractionResponse, options ...discordgo.RequestOption) error
+	// InteractionResponseEdit changes the response already sent, for 15 minutes after the interaction
+	InteractionResponseEdit(interaction *discordgo.Interaction, newresp *discordgo.WebhookEdit, options ...discordgo.RequestOption) (*discordgo.Message, error)
+}
+
+// Messenger sends messages to text channels
+type Messenger interface {
+	ChannelMessageSendEmbeds(channelID string, embeds []*discordgo.MessageEmbed, options ...discordgo.RequestOption) (*discordgo.Message, error)
+}
+
+// VoiceJoiner joins voice channels
+type VoiceJoiner interface {
+	JoinVoice(guildID string, channelID string) (VoiceConnection, error)
+}
+
+// StateLookup reads the state of the servers cached by the session
+type StateLookup interface {
+	// BotUserID returns the id of the bot user
+	BotUserID() string
+	// UserVoiceChannel returns the voice channel of the user, an empty string if not in one
+	UserVoiceChannel(guildID string, userID string) string
+	// VoiceChannelUsers returns the ids of the users in the voice channel
+	VoiceChannelUsers(guildID string, channelID string) []string
+	// IsBot returns true if the user is a bot
+	IsBot(guildID string, userID string) bool
+	// RoleName returns the name of the role, an empty string if not found
+	RoleName(guildID string, roleID string) string
+	// ChannelBitrate returns the bitrate of the voice channel in bps, 0 if not found
+	ChannelBitrate(guildID string, channelID string) int
+}
+
+// Session is everything the commands need from discord
+type Session interface {
+	Responder
+	Messenger
+	VoiceJoiner
+	StateLookup
+}
+
+// VoiceConnection is a connection to a voice channel
+type VoiceConnection interface {
+	ChannelID() string
+	Ready() bool
+	Speaking(speaking bool) error
+	// OpusSend returns the channel where the opus frames to play are sent
+	OpusSend() chan<- []byte
+	Disconnect() error
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/matthew-balzan/eido/internal/vars"
+)
+
+// Resumed checks the voice connections after the gateway reconnected, they may have been lost while it was down
+func Resumed(s *discordgo.Session, r *discordgo.Resumed) {
+	checkVoiceConnections()
+}
+
+// Ready checks the voice connections after a new gateway session, which happens when the old one couldn't be resumed
+func Ready(s *discordgo.Session, r *discordgo.Ready) {
+	checkVoiceConnections()
+}
+
+// VoiceServerUpdate gives the voice connection some time to connect to the new voice server, ex. after a region change.
+// discordgo connects to it by itself, the connection is replaced only if it doesn't get ready
+func VoiceServerUpdate(s *discordgo.Session, vs *discordgo.VoiceServerUpdate) {
+	instance := vars.Instances.Get(vs.GuildID)
+	if instance == nil {
+		return
+	}
+
+	log.Println("Voice server changed to " + vs.Endpoint)
+	instance.Do(func() {
+		instance.Voice.CheckVoice(false)
+	})
+}
+
+func checkVoiceConnections() {
+	for _, instance := range vars.Instances.All() {
+		instance.Do(func() {
+			instance.Voice.CheckVoice(true)
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/matthew-balzan/eido/internal/commands"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/vars"
+)
+
+// GuildCreate resumes the queue interrupted by the last shutdown, when the server becomes available
+func GuildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
+	instance := vars.Instances.Get(g.ID)
+	if instance == nil {
+		return
+	}
+
+	commands.ResumeSession(discord.NewSession(s), instance, vars.Config())
+}
@@ -2,28 +2,38 @@ package handlers
 
 import (
 	"log"
+	"sync/atomic"
 
 	"github.com/bwmarrin/discordgo"
 
 	"github.com/matthew-balzan/eido/internal/commands"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/models"
 	"github.com/matthew-balzan/eido/internal/vars"
 )
 
+// stopped is set at shutdown, the interactions received after are refused
+var stopped atomic.Bool
+
+// StopInteractions refuses the commands and buttons received from now on
+func StopInteractions() {
+	stopped.Store(true)
+}
+
 func InteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	// Ignore messages by the bot
-	if i.Member.User.ID == s.State.User.ID {
+	if commands.InteractionUser(i).ID == s.State.User.ID {
 		return
 	}
 
-	instance := vars.Instances[i.GuildID]
-	if instance == nil {
-		vars.Instances[i.GuildID] = commands.CreateServerInstance(i.GuildID)
-		instance = vars.Instances[i.GuildID]
-	}
-
 	// Log call
 	middlewareLogger(s, i)
 
+	if stopped.Load() {
+		commands.SendSimpleMessageResponse(discord.NewSession(s), i, "The bot is shutting down, try again when it's back", models.ColorError)
+		return
+	}
+
 	// Catch panic error
 	defer func() {
 		if r := recover(); r != nil {
@@ -34,31 +44,46 @@ func InteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	// Check the interaction type
 	switch i.Type {
 	case discordgo.InteractionApplicationCommand:
-		// Handle the slash command
-		switch i.ApplicationCommandData().Name {
-		case "ping":
-			commands.PingCommand(s, i)
-		case "play":
-			commands.PlayCommand(s, i, instance, vars.Config)
-		case "disconnect":
-			commands.Disconnect(s, i, instance)
-		case "skip":
-			commands.SkipSong(s, i, instance)
-		case "pause":
-			commands.PauseSong(s, i, instance)
-		case "resume":
-			commands.ResumeSong(s, i, instance)
-		case "clear":
-			commands.ClearQueue(s, i, instance)
-		case "queue":
-			commands.GetQueue(s, i, instance)
+		command := commands.FindCommand(i.ApplicationCommandData().Name)
+		if command == nil {
+			return
+		}
+
+		session := discord.NewSession(s)
+
+		// Direct message
+		if i.GuildID == "" {
+			command.Run(session, i, nil, vars.Config())
+			return
+		}
+
+		instance := vars.Instances.GetOrCreate(i.GuildID)
+
+		// Commands of the same server run one at a time on its loop, see Command.Run
+		command.Run(session, i, instance, vars.Config())
+	case discordgo.InteractionMessageComponent:
+		session := discord.NewSession(s)
+
+		if i.GuildID == "" {
+			commands.HandleComponent(session, i, nil, vars.Config())
+			return
 		}
 
+		instance := vars.Instances.GetOrCreate(i.GuildID)
+
+		instance.Do(func() {
+			commands.HandleComponent(session, i, instance, vars.Config())
+		})
 	}
 }
 
 func middlewareLogger(_ *discordgo.Session, i *discordgo.InteractionCreate) {
-	username := i.Member.User.Username
-	command := i.ApplicationCommandData().Name
-	log.Println(username + " used: " + command)
+	username := commands.InteractionUser(i).Username
+
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		log.Println(username + " used: " + i.ApplicationCommandData().Name)
+	case discordgo.InteractionMessageComponent:
+		log.Println(username + " pressed: " + i.MessageComponentData().CustomID)
+	}
 }
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/matthew-balzan/eido/internal/commands"
+	"github.com/matthew-balzan/eido/internal/discord"
+	"github.com/matthew-balzan/eido/internal/vars"
+)
+
+func VoiceStateUpdate(s *discordgo.Session, vs *discordgo.VoiceStateUpdate) {
+	instance := vars.Instances.Get(vs.GuildID)
+	if instance == nil {
+		return
+	}
+
+	instance.Do(func() {
+		commands.HandleVoiceStateUpdate(discord.NewSession(s), vs, instance, vars.Config())
+	})
+}
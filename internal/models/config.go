@@ -1,6 +1,67 @@
 package models
 
+import "slices"
+
 type Config struct {
+	Env string // environment of the config file, "prod" or "dev"
+
 	DiscordToken string `mapstructure:"DISCORD_TOKEN"`
 	YoutubeKey   string `mapstructure:"YOUTUBE_KEY"`
+
+	YoutubeQuotaBudget     int    `mapstructure:"YOUTUBE_QUOTA_BUDGET"`      // units of the youtube api the bot can spend in a day, then it uses yt-dlp
+	YoutubeCacheTTLMinutes int    `mapstructure:"YOUTUBE_CACHE_TTL_MINUTES"` // minutes the metadata of the videos is kept, 0 to disable the cache
+	YoutubeRegion          string `mapstructure:"YOUTUBE_REGION"`            // country code where the bot runs, to skip the videos blocked there
+
+	DevGuilds                 []string `mapstructure:"DEV_GUILDS"`                   // in dev, the commands are registered only in these guilds
+	CleanupCommandsOnShutdown bool     `mapstructure:"CLEANUP_COMMANDS_ON_SHUTDOWN"` // delete the registered commands when the bot stops
+
+	AutoplayRepeatWindow int `mapstructure:"AUTOPLAY_REPEAT_WINDOW"` // number of last played songs that autoplay won't repeat
+
+	DJRole             string  `mapstructure:"DJ_ROLE"`             // name or id of the dj role, empty to let everyone control the player
+	CommandPermissions string  `mapstructure:"COMMAND_PERMISSIONS"` // overrides of the permission levels, ex. "clear=everyone,skip=dj"
+	VoteSkipRatio      float64 `mapstructure:"VOTE_SKIP_RATIO"`     // ratio of listeners needed to vote skip a song
+
+	EmptyChannelTimeoutSeconds int64 `mapstructure:"EMPTY_CHANNEL_TIMEOUT_SECONDS"` // seconds to wait before leaving an empty voice channel
+	IdleTimeoutSeconds         int64 `mapstructure:"IDLE_TIMEOUT_SECONDS"`          // seconds idle or paused before leaving the voice channel, 0 to never leave
+
+	CrossfadeSeconds int `mapstructure:"CROSSFADE_SECONDS"` // seconds the end of a song is mixed with the start of the next one, 0 to disable
+
+	EncodingProfile  string                     `mapstructure:"ENCODING_PROFILE"`  // profile used by the servers that didn't choose one
+	EncodingProfiles map[string]EncodingProfile `mapstructure:"ENCODING_PROFILES"` // custom profiles, in addition to the built-in ones
+
+	StreamBufferKB            int `mapstructure:"STREAM_BUFFER_KB"`             // audio downloaded ahead for each song
+	GuildMemoryLimitMB        int `mapstructure:"GUILD_MEMORY_LIMIT_MB"`        // memory the songs of a server can use
+	MemoryLimitMB             int `mapstructure:"MEMORY_LIMIT_MB"`              // memory the songs of all the servers can use
+	MetricsLogIntervalSeconds int `mapstructure:"METRICS_LOG_INTERVAL_SECONDS"` // seconds between the logs of the metrics, 0 to disable
+
+	CacheDir             string   `mapstructure:"CACHE_DIR"`              // folder of the audio cache, empty for the cache folder of the user
+	CacheMaxMB           int      `mapstructure:"CACHE_MAX_MB"`           // size of the audio cache, 0 to disable it
+	CacheWarmupPlaylists []string `mapstructure:"CACHE_WARMUP_PLAYLISTS"` // playlists saved in the cache at startup
+
+	StateFile string `mapstructure:"STATE_FILE"` // file where the settings of the servers are saved between restarts
+}
+
+// Profile returns the encoding profile with the name, either custom or built-in
+func (c *Config) Profile(name string) (profile EncodingProfile, res bool) {
+	base := EncodingProfiles[DefaultEncodingProfile]
+
+	if profile, ok := c.EncodingProfiles[name]; ok {
+		return profile.Merge(base), true
+	}
+	profile, res = EncodingProfiles[name]
+	return profile, res
+}
+
+// ProfileNames returns the names of the encoding profiles, custom and built-in
+func (c *Config) ProfileNames() (names []string) {
+	for name := range EncodingProfiles {
+		names = append(names, name)
+	}
+	for name := range c.EncodingProfiles {
+		if _, ok := EncodingProfiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
 }
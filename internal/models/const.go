@@ -4,6 +4,40 @@ const ColorError int = 15548997
 const ColorDefault int = 10181046
 const ColorNeutral int = 9807270
 
-const TimeoutSecondsDisconnect int64 = 1000
+const DefaultIdleTimeoutSeconds int64 = 1000
+const MaxIdleTimeoutMinutes int = 24 * 60 // longest idle time a server can choose with /idle
+const DefaultEmptyChannelTimeoutSeconds int64 = 60
 
 const MaxQueueLength int = 100
+const MaxHistoryLength int = 100
+
+const HelpPageSize int = 10
+
+const DefaultStreamBufferKB int = 1024  // about a minute of audio downloaded ahead
+const DefaultBufferedFrames int = 250   // 5 seconds of audio encoded ahead
+const MaxBufferedFrames int = 3000      // 1 minute
+const DefaultGuildMemoryLimitMB int = 8 // enough for the song playing and the next one
+const DefaultMemoryLimitMB int = 256
+const DefaultCacheMaxMB int = 2048
+
+const DefaultYoutubeQuotaBudget int = 10000 // the default daily quota of a youtube api project
+const DefaultYoutubeCacheTTLMinutes int = 360
+
+const MaxCrossfadeSeconds int = 12 // the end of the song is kept in memory to mix it with the next one
+
+const StreamRetries int = 3               // times a song is opened again when its stream ends before the song
+const StreamRetryBackoffSeconds int64 = 2 // wait before the first retry, doubled at each one
+const StreamEndToleranceSeconds int64 = 5 // a stream that ends this close to the duration of the song is complete
+
+const VoiceCheckSeconds int64 = 5         // interval of the checks of the voice connection
+const VoiceRecoverySeconds int64 = 15     // a voice connection not ready for this long is opened again
+const VoiceRejoinAttempts int = 3         // times the bot tries to join the channel again before giving up
+const VoiceRejoinBackoffSeconds int64 = 2 // wait before the first new attempt, doubled at each one
+const VoiceWaitSeconds int64 = 90         // time a song waits for the voice connection before it's dropped
+
+const DefaultAutoplayRepeatWindow int = 20
+const DefaultVoteSkipRatio float64 = 0.5
+
+const DefaultStateFile string = "eido-state.json"
+
+const ShutdownTimeoutSeconds int64 = 10 // time to stop the sessions and the downloads before the bot exits anyway
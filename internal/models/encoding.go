@@ -0,0 +1,76 @@
+package models
+
+// EncodingProfile are the settings used to download and encode the songs.
+// In a custom profile of the config, the fields not set take the value of the default profile
+type EncodingProfile struct {
+	Bitrate        int     `mapstructure:"bitrate"`         // kbps, 0 to follow the bitrate of the voice channel
+	Application    string  `mapstructure:"application"`     // opus application: "audio", "voip" or "lowdelay"
+	Volume         float64 `mapstructure:"volume"`          // 1 is the original volume
+	BufferedFrames int     `mapstructure:"buffered_frames"` // frames that ffmpeg can encode ahead
+	Format         string  `mapstructure:"format"`          // yt-dlp format selection, "/" separates the fallbacks
+}
+
+// DefaultYtdlpFormat prefers an opus audio stream, which needs no conversion,
+// then any audio stream, then any stream with audio
+const DefaultYtdlpFormat string = "bestaudio[acodec=opus]/bestaudio/best*[acodec!=none]"
+
+const DefaultEncodingProfile string = "default"
+
+// EncodingProfiles are the profiles available without configuring them
+var EncodingProfiles = map[string]EncodingProfile{
+	"default": {Bitrate: 0, Application: "lowdelay", Volume: 0.1, BufferedFrames: DefaultBufferedFrames, Format: DefaultYtdlpFormat},
+	"music":   {Bitrate: 0, Application: "audio", Volume: 0.1, BufferedFrames: DefaultBufferedFrames, Format: DefaultYtdlpFormat},
+	// the songs in opus are sent as they are, without using CPU to encode them again. The volume can't be lowered
+	"passthrough": {Bitrate: 0, Application: "audio", Volume: 1, BufferedFrames: DefaultBufferedFrames, Format: DefaultYtdlpFormat},
+	"low":         {Bitrate: 64, Application: "lowdelay", Volume: 0.1, BufferedFrames: DefaultBufferedFrames, Format: "bestaudio[acodec=opus][abr<=96]/" + DefaultYtdlpFormat},
+}
+
+// OpusApplications are the valid values of EncodingProfile.Application
+var OpusApplications = []string{"audio", "voip", "lowdelay"}
+
+// ChannelBitrates are the bitrates used when following the voice channel, in kbps.
+// Voice channels go up to 96 kbps, and up to 384 in boosted servers
+var ChannelBitrates = []int{64, 96, 128, 384}
+
+const FallbackBitrate int = 96
+
+// Merge returns the profile with the fields not set taken from base
+func (p EncodingProfile) Merge(base EncodingProfile) EncodingProfile {
+	if p.Bitrate == 0 {
+		p.Bitrate = base.Bitrate
+	}
+	if p.Application == "" {
+		p.Application = base.Application
+	}
+	if p.Volume == 0 {
+		p.Volume = base.Volume
+	}
+	if p.BufferedFrames == 0 {
+		p.BufferedFrames = base.BufferedFrames
+	}
+	if p.Format == "" {
+		p.Format = base.Format
+	}
+	return p
+}
+
+// BitrateFor returns the bitrate to use in a voice channel with the bitrate `channelBitrate`, in bps.
+// Following the channel, it's the highest of ChannelBitrates the channel allows,
+// or the one of the channel if lower than all of them
+func (p EncodingProfile) BitrateFor(channelBitrate int) int {
+	if p.Bitrate != 0 {
+		return p.Bitrate
+	}
+	if channelBitrate <= 0 { // unknown
+		return FallbackBitrate
+	}
+
+	kbps := channelBitrate / 1000
+	bitrate := kbps
+	for _, b := range ChannelBitrates {
+		if b <= kbps {
+			bitrate = b
+		}
+	}
+	return max(bitrate, 8)
+}
@@ -0,0 +1,26 @@
+package models
+
+type PermissionLevel int
+
+const (
+	PermissionEveryone PermissionLevel = iota
+	PermissionDJ
+	PermissionAdmin
+)
+
+// PermissionLevels maps the names usable in the config to the levels
+var PermissionLevels = map[string]PermissionLevel{
+	"everyone": PermissionEveryone,
+	"dj":       PermissionDJ,
+	"admin":    PermissionAdmin,
+}
+
+// String returns the name of the level, as written in the config
+func (l PermissionLevel) String() string {
+	for name, level := range PermissionLevels {
+		if level == l {
+			return name
+		}
+	}
+	return "unknown"
+}
@@ -1,16 +1,44 @@
 package utils
 
 import (
+	"errors"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 
+	"github.com/matthew-balzan/eido/internal/commands"
 	"github.com/matthew-balzan/eido/internal/models"
 	"github.com/matthew-balzan/eido/internal/vars"
 )
 
-func LoadConfig() (config models.Config, err error) {
+// configLock is held while viper reads the config, which is not safe for concurrent use:
+// the file watcher and SIGHUP can reload it at the same time
+var configLock sync.Mutex
+
+// configPaths are the folders where the config file is searched, in order
+func configPaths() (paths []string) {
+	paths = []string{"."}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "eido"))
+	}
+	return append(paths, "/etc/eido")
+}
+
+// LoadConfig reads the config and sets it as the global one.
+// The file is `path` if not empty, otherwise `config-<env>` with any extension supported by viper
+// (.env, .yaml, .toml, ...) from the config paths. Without a file, the config comes only from the environment.
+// Environment variables always take precedence over the file
+func LoadConfig(path string) (config models.Config, err error) {
+	configLock.Lock()
+	defer configLock.Unlock()
 
 	var env = "prod"
 	var envCommand = os.Getenv("APP_ENV")
@@ -19,21 +47,228 @@ func LoadConfig() (config models.Config, err error) {
 		env = envCommand
 	}
 
-	viper.AddConfigPath("../../")
-	viper.SetConfigFile("config-" + env + ".env")
+	if path != "" {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName("config-" + env)
+		for _, p := range configPaths() {
+			viper.AddConfigPath(p)
+		}
+	}
 
 	viper.AutomaticEnv()
 
+	// keys without a default are read from the environment only if bound
+	viper.BindEnv("DISCORD_TOKEN")
+	viper.BindEnv("YOUTUBE_KEY")
+
+	viper.SetDefault("YOUTUBE_QUOTA_BUDGET", models.DefaultYoutubeQuotaBudget)
+	viper.SetDefault("YOUTUBE_CACHE_TTL_MINUTES", models.DefaultYoutubeCacheTTLMinutes)
+	viper.SetDefault("YOUTUBE_REGION", "")
+	viper.SetDefault("AUTOPLAY_REPEAT_WINDOW", models.DefaultAutoplayRepeatWindow)
+	viper.SetDefault("DJ_ROLE", "")
+	viper.SetDefault("COMMAND_PERMISSIONS", "")
+	viper.SetDefault("VOTE_SKIP_RATIO", models.DefaultVoteSkipRatio)
+	viper.SetDefault("EMPTY_CHANNEL_TIMEOUT_SECONDS", models.DefaultEmptyChannelTimeoutSeconds)
+	viper.SetDefault("IDLE_TIMEOUT_SECONDS", models.DefaultIdleTimeoutSeconds)
+	viper.SetDefault("CROSSFADE_SECONDS", 0)
+	viper.SetDefault("DEV_GUILDS", []string{})
+	viper.SetDefault("CLEANUP_COMMANDS_ON_SHUTDOWN", false)
+	viper.SetDefault("STATE_FILE", models.DefaultStateFile)
+	viper.SetDefault("ENCODING_PROFILE", models.DefaultEncodingProfile)
+	viper.SetDefault("STREAM_BUFFER_KB", models.DefaultStreamBufferKB)
+	viper.SetDefault("GUILD_MEMORY_LIMIT_MB", models.DefaultGuildMemoryLimitMB)
+	viper.SetDefault("MEMORY_LIMIT_MB", models.DefaultMemoryLimitMB)
+	viper.SetDefault("METRICS_LOG_INTERVAL_SECONDS", 0)
+	viper.SetDefault("CACHE_DIR", "")
+	viper.SetDefault("CACHE_MAX_MB", models.DefaultCacheMaxMB)
+	viper.SetDefault("CACHE_WARMUP_PLAYLISTS", []string{})
+
 	err = viper.ReadInConfig()
+	var notFound viper.ConfigFileNotFoundError
+	if errors.As(err, &notFound) {
+		log.Println("No config file found, using only the environment")
+		err = nil
+	}
 	if err != nil {
 		return
 	}
 
 	err = viper.Unmarshal(&config)
+	if err != nil {
+		return
+	}
+	config.Env = env
 
 	// set config as global variable
-	vars.Config = &config
+	vars.SetConfig(&config)
 
-	log.Println("Configs loaded!")
+	if file := viper.ConfigFileUsed(); file != "" {
+		log.Println("Configs loaded from " + file + "!")
+	} else {
+		log.Println("Configs loaded!")
+	}
 	return
 }
+
+// WatchConfig reloads the config when its file changes.
+// Only the settings that can change while running are applied: the secrets, the environment,
+// the dev guilds, the state file and the cache keep the values read at startup.
+// An invalid config is ignored, keeping the current one
+func WatchConfig() {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println("ERR: internal/utils/config.go: Error watching the config file - ", err)
+		return
+	}
+	// the folder is watched, so the file is followed when it's replaced, ex. by an editor or a mounted volume
+	if err = watcher.Add(filepath.Dir(file)); err != nil {
+		log.Println("ERR: internal/utils/config.go: Error watching the config file - ", err)
+		watcher.Close()
+		return
+	}
+
+	go func() {
+		target, _ := filepath.EvalSymlinks(file)
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(file)
+				written := filepath.Clean(event.Name) == filepath.Clean(file) && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+				if written || (current != "" && current != target) {
+					target = current
+					ReloadConfig()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Println("ERR: internal/utils/config.go: Error watching the config file - ", err)
+			}
+		}
+	}()
+}
+
+// ReloadConfig reads the config file again and applies the settings that can change while running
+func ReloadConfig() {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	if viper.ConfigFileUsed() == "" {
+		log.Println("No config file to reload, the settings are read only from the environment")
+		return
+	}
+
+	current := vars.Config()
+
+	var config models.Config
+	if err := viper.ReadInConfig(); err != nil {
+		log.Println("ERR: internal/utils/config.go: Error reloading the config - ", err)
+		return
+	}
+	if err := viper.Unmarshal(&config); err != nil {
+		log.Println("ERR: internal/utils/config.go: Error reloading the config - ", err)
+		return
+	}
+
+	config.Env = current.Env
+	config.DiscordToken = current.DiscordToken
+	config.YoutubeKey = current.YoutubeKey
+	config.DevGuilds = current.DevGuilds
+	config.StateFile = current.StateFile
+	config.CacheDir = current.CacheDir
+	config.CacheMaxMB = current.CacheMaxMB
+
+	if err := ValidateConfig(&config); err != nil {
+		log.Println("ERR: internal/utils/config.go: Config not reloaded, it's invalid - ", err)
+		return
+	}
+
+	vars.SetConfig(&config)
+	log.Println("Configs reloaded!")
+}
+
+var regRegion = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// ValidateConfig checks that the settings are usable, and returns all the problems found
+func ValidateConfig(config *models.Config) (err error) {
+	var errs []error
+
+	if config.DiscordToken == "" {
+		errs = append(errs, errors.New("DISCORD_TOKEN is missing"))
+	} else if !strings.HasPrefix(config.DiscordToken, "Bot ") {
+		errs = append(errs, errors.New("DISCORD_TOKEN must start with \"Bot \""))
+	}
+	if config.YoutubeKey == "" {
+		errs = append(errs, errors.New("YOUTUBE_KEY is missing"))
+	}
+	if config.YoutubeQuotaBudget < 0 || config.YoutubeCacheTTLMinutes < 0 {
+		errs = append(errs, errors.New("YOUTUBE_QUOTA_BUDGET and YOUTUBE_CACHE_TTL_MINUTES can't be negative"))
+	}
+	if config.YoutubeRegion != "" && !regRegion.MatchString(config.YoutubeRegion) {
+		errs = append(errs, errors.New("YOUTUBE_REGION must be a country code of two uppercase letters, ex. \"IT\""))
+	}
+	if config.AutoplayRepeatWindow < 0 {
+		errs = append(errs, errors.New("AUTOPLAY_REPEAT_WINDOW can't be negative"))
+	}
+	if config.VoteSkipRatio <= 0 || config.VoteSkipRatio > 1 {
+		errs = append(errs, errors.New("VOTE_SKIP_RATIO must be between 0 (excluded) and 1"))
+	}
+	if config.EmptyChannelTimeoutSeconds < 0 {
+		errs = append(errs, errors.New("EMPTY_CHANNEL_TIMEOUT_SECONDS can't be negative"))
+	}
+	if config.IdleTimeoutSeconds < 0 {
+		errs = append(errs, errors.New("IDLE_TIMEOUT_SECONDS can't be negative"))
+	}
+	if config.CrossfadeSeconds < 0 || config.CrossfadeSeconds > models.MaxCrossfadeSeconds {
+		errs = append(errs, errors.New("CROSSFADE_SECONDS must be between 0 and "+strconv.Itoa(models.MaxCrossfadeSeconds)))
+	}
+	if err := commands.ValidatePermissions(config); err != nil {
+		errs = append(errs, err)
+	}
+	if _, ok := config.Profile(config.EncodingProfile); !ok {
+		errs = append(errs, errors.New("ENCODING_PROFILE: unknown profile \""+config.EncodingProfile+"\""))
+	}
+	for name, profile := range config.EncodingProfiles {
+		if profile.Bitrate != 0 && (profile.Bitrate < 8 || profile.Bitrate > 512) {
+			errs = append(errs, errors.New("ENCODING_PROFILES: the bitrate of \""+name+"\" must be between 8 and 512 kbps, or 0 to follow the channel"))
+		}
+		if profile.Application != "" && !slices.Contains(models.OpusApplications, profile.Application) {
+			errs = append(errs, errors.New("ENCODING_PROFILES: the application of \""+name+"\" must be one of "+strings.Join(models.OpusApplications, ", ")))
+		}
+		if profile.Volume < 0 || profile.BufferedFrames < 0 {
+			errs = append(errs, errors.New("ENCODING_PROFILES: the volume and buffered frames of \""+name+"\" can't be negative"))
+		}
+		if profile.BufferedFrames > models.MaxBufferedFrames {
+			errs = append(errs, errors.New("ENCODING_PROFILES: the buffered frames of \""+name+"\" can't be more than "+strconv.Itoa(models.MaxBufferedFrames)))
+		}
+	}
+	if config.StreamBufferKB < 64 {
+		errs = append(errs, errors.New("STREAM_BUFFER_KB must be at least 64"))
+	}
+	if config.GuildMemoryLimitMB*1024 < config.StreamBufferKB {
+		errs = append(errs, errors.New("GUILD_MEMORY_LIMIT_MB must fit at least one stream buffer (STREAM_BUFFER_KB)"))
+	}
+	if config.MemoryLimitMB < config.GuildMemoryLimitMB {
+		errs = append(errs, errors.New("MEMORY_LIMIT_MB can't be lower than GUILD_MEMORY_LIMIT_MB"))
+	}
+	if config.MetricsLogIntervalSeconds < 0 {
+		errs = append(errs, errors.New("METRICS_LOG_INTERVAL_SECONDS can't be negative"))
+	}
+	if config.CacheMaxMB < 0 {
+		errs = append(errs, errors.New("CACHE_MAX_MB can't be negative"))
+	}
+	if config.StateFile == "" {
+		errs = append(errs, errors.New("STATE_FILE is empty"))
+	}
+
+	return errors.Join(errs...)
+}
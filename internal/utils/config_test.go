@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/matthew-balzan/eido/internal/models"
+	"github.com/matthew-balzan/eido/internal/vars"
+)
+
+// useConfig isolates the test from the config files of the machine and from the config of the other tests
+func useConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("APP_ENV", "dev")
+	t.Setenv("DISCORD_TOKEN", "Bot token")
+	t.Setenv("YOUTUBE_KEY", "key")
+
+	previous := vars.Config()
+	t.Cleanup(func() {
+		viper.Reset()
+		vars.SetConfig(previous)
+	})
+}
+
+// writeConfig writes the config file and returns its path
+func writeConfig(t *testing.T, path string, content string) string {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "config.yaml")
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadConfigEnv loads the config without a file, from the environment and the defaults, like in a container
+func TestLoadConfigEnv(t *testing.T) {
+	useConfig(t)
+	t.Setenv("VOTE_SKIP_RATIO", "0.75")
+
+	config, err := LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if viper.ConfigFileUsed() != "" {
+		t.Fatalf("config read from %s, want only the environment", viper.ConfigFileUsed())
+	}
+
+	if config.DiscordToken != "Bot token" || config.YoutubeKey != "key" || config.VoteSkipRatio != 0.75 {
+		t.Fatalf("settings of the environment not read: %+v", config)
+	}
+	if config.Env != "dev" || config.StateFile != models.DefaultStateFile || config.StreamBufferKB != models.DefaultStreamBufferKB {
+		t.Fatalf("defaults not applied: %+v", config)
+	}
+	if err = ValidateConfig(&config); err != nil {
+		t.Fatalf("config of the environment invalid: %v", err)
+	}
+	if vars.Config().DiscordToken != "Bot token" {
+		t.Fatal("config not set as the global one")
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	useConfig(t)
+	t.Setenv("IDLE_TIMEOUT_SECONDS", "30")
+	path := writeConfig(t, "", "VOTE_SKIP_RATIO: 0.25\nIDLE_TIMEOUT_SECONDS: 600\n")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.VoteSkipRatio != 0.25 {
+		t.Fatalf("vote skip ratio %v, want the one of the file", config.VoteSkipRatio)
+	}
+	if config.IdleTimeoutSeconds != 30 {
+		t.Fatalf("idle timeout %d, want the one of the environment over the file", config.IdleTimeoutSeconds)
+	}
+
+	if _, err = LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("missing config file given with --config loaded without errors")
+	}
+}
+
+// TestReloadConfig changes the file: only the settings that can change while running are applied
+func TestReloadConfig(t *testing.T) {
+	useConfig(t)
+	path := writeConfig(t, "", "VOTE_SKIP_RATIO: 0.25\nSTATE_FILE: first.json\n")
+	if _, err := LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfig(t, path, "VOTE_SKIP_RATIO: 0.75\nSTATE_FILE: second.json\n")
+	ReloadConfig()
+	if config := vars.Config(); config.VoteSkipRatio != 0.75 || config.StateFile != "first.json" {
+		t.Fatalf("ratio %v and state file %s after the reload, want 0.75 and the one read at startup", config.VoteSkipRatio, config.StateFile)
+	}
+
+	// an invalid config is not applied
+	writeConfig(t, path, "VOTE_SKIP_RATIO: 2\n")
+	ReloadConfig()
+	if ratio := vars.Config().VoteSkipRatio; ratio != 0.75 {
+		t.Fatalf("ratio %v after reloading an invalid config, want the previous one", ratio)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	useConfig(t)
+	valid, err := LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(config *models.Config)
+		errors []string
+	}{
+		{"valid", func(config *models.Config) {}, nil},
+		{"token missing", func(config *models.Config) {
+			config.DiscordToken = ""
+		}, []string{"DISCORD_TOKEN is missing"}},
+		{"token without prefix", func(config *models.Config) {
+			config.DiscordToken = "token"
+		}, []string{`DISCORD_TOKEN must start with "Bot "`}},
+		{"region", func(config *models.Config) {
+			config.YoutubeRegion = "it"
+		}, []string{"YOUTUBE_REGION must be a country code"}},
+		{"crossfade", func(config *models.Config) {
+			config.CrossfadeSeconds = models.MaxCrossfadeSeconds + 1
+		}, []string{"CROSSFADE_SECONDS must be between 0 and 12"}},
+		{"profile", func(config *models.Config) {
+			config.EncodingProfile = "missing"
+		}, []string{`ENCODING_PROFILE: unknown profile "missing"`}},
+		{"memory", func(config *models.Config) {
+			config.MemoryLimitMB = config.GuildMemoryLimitMB - 1
+		}, []string{"MEMORY_LIMIT_MB can't be lower than GUILD_MEMORY_LIMIT_MB"}},
+		{"every problem reported", func(config *models.Config) {
+			config.YoutubeKey = ""
+			config.VoteSkipRatio = 0
+			config.StateFile = ""
+		}, []string{"YOUTUBE_KEY is missing", "VOTE_SKIP_RATIO must be between 0 (excluded) and 1", "STATE_FILE is empty"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := valid
+			test.change(&config)
+
+			err := ValidateConfig(&config)
+			if len(test.errors) == 0 {
+				if err != nil {
+					t.Fatalf("valid config: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("invalid config accepted")
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(test.errors) {
+				t.Fatalf("errors %q, want %q", lines, test.errors)
+			}
+			for j, want := range test.errors {
+				if !strings.HasPrefix(lines[j], want) {
+					t.Fatalf("errors %q, want %q", lines, test.errors)
+				}
+			}
+		})
+	}
+}
@@ -1,11 +1,24 @@
 package vars
 
 import (
+	"sync/atomic"
+
 	"github.com/matthew-balzan/eido/internal/commands"
 	"github.com/matthew-balzan/eido/internal/models"
 )
 
 var (
-	Config    *models.Config
-	Instances = map[string]*commands.ServerInstance{}
+	config    atomic.Pointer[models.Config]
+	Instances = commands.NewRegistry()
 )
+
+// Config returns the current config. It's replaced when the config file is reloaded,
+// so it should be read once per operation and never modified
+func Config() *models.Config {
+	return config.Load()
+}
+
+// SetConfig replaces the current config
+func SetConfig(c *models.Config) {
+	config.Store(c)
+}
@@ -0,0 +1,83 @@
+package youtube
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache keeps values for a time, up to a number of entries
+type ttlCache[V any] struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]ttlEntry[V]
+	hits    int64
+}
+
+type ttlEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+func newTTLCache[V any](ttl time.Duration, max int) *ttlCache[V] {
+	return &ttlCache[V]{ttl: ttl, max: max, entries: map[string]ttlEntry[V]{}}
+}
+
+func (c *ttlCache[V]) get(key string) (value V, res bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return value, false
+	}
+	c.hits++
+	return entry.value, true
+}
+
+func (c *ttlCache[V]) set(key string, value V) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.ttl <= 0 {
+		return
+	}
+	if len(c.entries) >= c.max {
+		c.prune()
+	}
+	c.entries[key] = ttlEntry[V]{value: value, expires: time.Now().Add(c.ttl)}
+}
+
+// prune deletes the expired entries, and the ones expiring first if still full. It must be called with the lock
+func (c *ttlCache[V]) prune() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+
+	for len(c.entries) >= c.max {
+		var oldest string
+		for key, entry := range c.entries {
+			if oldest == "" || entry.expires.Before(c.entries[oldest].expires) {
+				oldest = key
+			}
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+func (c *ttlCache[V]) setTTL(ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.ttl = ttl
+}
+
+func (c *ttlCache[V]) stats() (entries int, hits int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.entries), c.hits
+}
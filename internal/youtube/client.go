@@ -0,0 +1,486 @@
+// Package youtube reads the metadata of the videos with the youtube api, caching the results and counting the quota spent.
+// When the quota of the day is over, it reads them with yt-dlp instead
+package youtube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/option"
+	yt "google.golang.org/api/youtube/v3"
+)
+
+// cache sizes, enough for a few days of a busy bot
+const (
+	maxCachedVideos    = 10000
+	maxCachedPlaylists = 500
+	maxCachedSearches  = 5000
+)
+
+// maxBatch is the maximum number of videos read with one call of the api
+const maxBatch = 50
+
+// Video is the metadata of a video
+type Video struct {
+	ID        string
+	Title     string
+	Author    string
+	Duration  string // in the api format without "PT" (ex. "3M20S"), or in the yt-dlp format (ex. "3:20")
+	Thumbnail string
+}
+
+// Unavailable is a video that can't be played, with the reason (ex. "private", "deleted", "blocked in IT")
+type Unavailable struct {
+	ID     string
+	Title  string
+	Reason string
+}
+
+// Playlist are the videos of a playlist, split between the ones that can be played and the others
+type Playlist struct {
+	Videos      []Video
+	Unavailable []Unavailable
+}
+
+// videoResult is a video read from the api, with the reason if it can't be played
+type videoResult struct {
+	video  Video
+	reason string
+}
+
+// Client is the youtube client shared by all the servers
+type Client struct {
+	service *yt.Service
+	quota   *Quota
+	region  atomic.Value // string, country where the videos are played
+
+	videos    *ttlCache[videoResult]
+	playlists *ttlCache[[]videoResult] // all the videos of the playlist, in order
+	searches  *ttlCache[string]        // query -> video id
+	fallbacks atomic.Int64             // calls made with yt-dlp instead of the api
+}
+
+func NewClient(key string, budget int, ttl time.Duration) (client *Client, err error) {
+	service, err := yt.NewService(context.Background(), option.WithAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	client = &Client{
+		service:   service,
+		quota:     NewQuota(budget),
+		videos:    newTTLCache[videoResult](ttl, maxCachedVideos),
+		playlists: newTTLCache[[]videoResult](ttl, maxCachedPlaylists),
+		searches:  newTTLCache[string](ttl, maxCachedSearches),
+	}
+	client.region.Store("")
+	return client, nil
+}
+
+// Configure changes the budget, the time the metadata is kept and the country of the region restrictions.
+// An empty region ignores the restrictions
+func (c *Client) Configure(budget int, ttl time.Duration, region string) {
+	c.quota.SetBudget(budget)
+	c.videos.setTTL(ttl)
+	c.playlists.setTTL(ttl)
+	c.searches.setTTL(ttl)
+	c.region.Store(region)
+}
+
+func (c *Client) Quota() *Quota {
+	return c.quota
+}
+
+// Video returns the metadata of the video
+func (c *Client) Video(ctx context.Context, id string) (video Video, err error) {
+	if result, ok := c.videos.get(id); ok {
+		return result.video, result.err()
+	}
+
+	var results []videoResult
+	err = ErrQuota
+	if c.quota.Spend("videos", CostVideos) {
+		results, err = c.apiVideos(ctx, []string{id})
+	}
+
+	if errors.Is(err, ErrQuota) {
+		video, err = c.ytdlpVideo(ctx, "https://www.youtube.com/watch?v="+id)
+		if err != nil {
+			return video, err
+		}
+		c.videos.set(id, videoResult{video: video})
+		return video, nil
+	}
+	if err != nil {
+		return video, err
+	}
+	return results[0].video, results[0].err()
+}
+
+func (r videoResult) err() error {
+	switch r.reason {
+	case "":
+		return nil
+	case reasonMissing:
+		return ErrNotFound
+	default:
+		return &UnavailableError{Reason: r.reason}
+	}
+}
+
+// reasonMissing is the reason of the videos not returned by the api: deleted, private or never existed
+const reasonMissing = "private or deleted"
+
+// Videos returns the metadata of the videos, in the same order, reading the ones not cached in batches of 50.
+// The videos that can't be played are returned apart
+func (c *Client) Videos(ctx context.Context, ids []string) (videos []Video, unavailable []Unavailable, err error) {
+	results, err := c.results(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	playlist := split(results)
+	return playlist.Videos, playlist.Unavailable, nil
+}
+
+// results returns the videos, in the same order, reading the ones not cached in batches of 50
+func (c *Client) results(ctx context.Context, ids []string) (results []videoResult, err error) {
+	found := make(map[string]videoResult, len(ids))
+
+	var missing []string
+	for _, id := range ids {
+		if result, ok := c.videos.get(id); ok {
+			found[id] = result
+		} else if !slices.Contains(missing, id) {
+			missing = append(missing, id)
+		}
+	}
+
+	for start := 0; start < len(missing); start += maxBatch {
+		batch := missing[start:min(start+maxBatch, len(missing))]
+		if !c.quota.Spend("videos", CostVideos) {
+			return nil, ErrQuota
+		}
+
+		list, err := c.apiVideos(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range list {
+			found[result.video.ID] = result
+		}
+	}
+
+	for _, id := range ids {
+		results = append(results, found[id])
+	}
+	return results, nil
+}
+
+// split divides the videos that can be played from the others, keeping the order
+func split(results []videoResult) (playlist Playlist) {
+	for _, result := range results {
+		if result.reason != "" {
+			playlist.Unavailable = append(playlist.Unavailable, Unavailable{ID: result.video.ID, Title: result.video.Title, Reason: result.reason})
+			continue
+		}
+		playlist.Videos = append(playlist.Videos, result.video)
+	}
+	return playlist
+}
+
+// apiVideos reads the videos with one call of the api, and caches them.
+// The quota must be already spent. The results are in the same order of the ids
+func (c *Client) apiVideos(ctx context.Context, ids []string) (results []videoResult, err error) {
+	res, err := c.service.Videos.List([]string{"contentDetails", "snippet", "status"}).Id(ids...).MaxResults(maxBatch).Context(ctx).Do()
+	if err != nil {
+		return results, c.apiError(err)
+	}
+
+	found := make(map[string]videoResult, len(res.Items))
+	for _, item := range res.Items {
+		found[item.Id] = c.videoResult(item)
+	}
+
+	for _, id := range ids {
+		result, ok := found[id]
+		if !ok {
+			result = videoResult{video: Video{ID: id}, reason: reasonMissing}
+		}
+		c.videos.set(id, result)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// videoResult returns the metadata of the video, and the reason if it can't be played
+func (c *Client) videoResult(item *yt.Video) (result videoResult) {
+	result.video = Video{ID: item.Id}
+
+	if item.Snippet != nil {
+		result.video.Title = item.Snippet.Title
+		result.video.Author = item.Snippet.ChannelTitle
+		if item.Snippet.Thumbnails != nil && item.Snippet.Thumbnails.Default != nil {
+			result.video.Thumbnail = item.Snippet.Thumbnails.Default.Url
+		}
+		if item.Snippet.LiveBroadcastContent == "upcoming" {
+			result.reason = "not released yet"
+		}
+	}
+
+	if item.ContentDetails != nil {
+		result.video.Duration = strings.ReplaceAll(item.ContentDetails.Duration, "PT", "")
+
+		region := c.region.Load().(string)
+		if restriction := item.ContentDetails.RegionRestriction; restriction != nil && region != "" {
+			if slices.Contains(restriction.Blocked, region) || (len(restriction.Allowed) > 0 && !slices.Contains(restriction.Allowed, region)) {
+				result.reason = "blocked in " + region
+			}
+		}
+	}
+
+	if item.Status != nil {
+		switch {
+		case item.Status.PrivacyStatus == "private":
+			result.reason = "private"
+		case item.Status.UploadStatus == "deleted" || item.Status.UploadStatus == "rejected" || item.Status.UploadStatus == "failed":
+			result.reason = item.Status.UploadStatus
+		}
+	}
+
+	return result
+}
+
+// Playlist returns the videos of the playlist, with their durations.
+// The ones that can't be played are returned apart
+func (c *Client) Playlist(ctx context.Context, id string) (playlist Playlist, err error) {
+	err = c.PlaylistRange(ctx, id, 0, 0, func(page Playlist, _ int) bool {
+		playlist.Videos = append(playlist.Videos, page.Videos...)
+		playlist.Unavailable = append(playlist.Unavailable, page.Unavailable...)
+		return true
+	})
+	if err == nil && len(playlist.Videos) == 0 && len(playlist.Unavailable) == 0 {
+		err = ErrNotFound
+	}
+	return playlist, err
+}
+
+// PlaylistRange reads the videos of the playlist from the position `first` (from 0) to `last` excluded, 0 for the end.
+// `page` is called with the videos of each page as soon as they are read, so they can be played before the end,
+// together with the number of videos of the range. Reading stops when `page` returns false
+func (c *Client) PlaylistRange(ctx context.Context, id string, first int, last int, page func(playlist Playlist, total int) bool) (err error) {
+	if results, ok := c.playlists.get(id); ok {
+		results = results[min(first, len(results)):]
+		if last > 0 {
+			results = results[:max(0, min(last-first, len(results)))]
+		}
+		page(split(results), len(results))
+		return nil
+	}
+
+	position, err := c.apiPlaylistRange(ctx, id, first, last, page)
+	if errors.Is(err, ErrQuota) {
+		return c.ytdlpPlaylistRange(ctx, id, position, last, page)
+	}
+	return err
+}
+
+// apiPlaylistRange reads the range of the playlist with the api, a page of 50 videos at a time.
+// It returns the position reached, to continue with yt-dlp if the quota is over
+func (c *Client) apiPlaylistRange(ctx context.Context, id string, first int, last int, page func(Playlist, int) bool) (position int, err error) {
+	position = first
+	index := 0 // position in the playlist of the next item read
+	whole := first == 0 && last == 0
+	var all []videoResult // the whole playlist, cached if read until the end
+
+	token := ""
+	for {
+		if !c.quota.Spend("playlistItems", CostPlaylistItems) {
+			return position, ErrQuota
+		}
+
+		res, err := c.service.PlaylistItems.List([]string{"contentDetails", "snippet"}).PlaylistId(id).MaxResults(maxBatch).PageToken(token).Context(ctx).Do()
+		if err != nil {
+			return position, c.apiError(err)
+		}
+
+		total := int(res.PageInfo.TotalResults)
+		if last > 0 {
+			total = min(total, last)
+		}
+		total = max(0, total-first)
+
+		// the api doesn't return the videos not available, but the playlist has their titles
+		var ids []string
+		titles := map[string]string{}
+		for _, item := range res.Items {
+			if item.ContentDetails == nil {
+				continue
+			}
+			if index >= first && (last == 0 || index < last) {
+				ids = append(ids, item.ContentDetails.VideoId)
+				if item.Snippet != nil {
+					titles[item.ContentDetails.VideoId] = item.Snippet.Title
+				}
+			}
+			index++
+		}
+
+		if len(ids) > 0 {
+			results, err := c.results(ctx, ids)
+			if err != nil {
+				return position, err
+			}
+			for j := range results {
+				if results[j].video.Title == "" {
+					results[j].video.Title = titles[results[j].video.ID]
+				}
+			}
+
+			position += len(results)
+			if whole {
+				all = append(all, results...)
+			}
+			if !page(split(results), total) {
+				return position, nil
+			}
+		}
+
+		token = res.NextPageToken
+		if token == "" || (last > 0 && index >= last) {
+			break
+		}
+	}
+
+	if whole {
+		c.playlists.set(id, all)
+	}
+	return position, nil
+}
+
+// ytdlpPlaylistRange reads the range of the playlist with yt-dlp, all at once.
+// Without the api the region restrictions are not known
+func (c *Client) ytdlpPlaylistRange(ctx context.Context, id string, first int, last int, page func(Playlist, int) bool) (err error) {
+	list, err := c.ytdlp(ctx, "https://www.youtube.com/playlist?list="+id, first, last)
+	if err != nil {
+		return err
+	}
+
+	var results []videoResult
+	for _, video := range list {
+		result := videoResult{video: video}
+		switch video.Title {
+		case "[Private video]":
+			result.reason = "private"
+		case "[Deleted video]":
+			result.reason = "deleted"
+		}
+		results = append(results, result)
+	}
+
+	if len(results) > 0 {
+		page(split(results), len(results))
+	}
+	return nil
+}
+
+// Search returns the id of the first video found for the query
+func (c *Client) Search(ctx context.Context, query string) (id string, err error) {
+	key := strings.ToLower(strings.Join(strings.Fields(query), " "))
+	if id, ok := c.searches.get(key); ok {
+		return id, nil
+	}
+
+	err = ErrQuota
+	if c.quota.Spend("search", CostSearch) {
+		id, err = c.apiSearch(ctx, query)
+	}
+
+	if errors.Is(err, ErrQuota) {
+		video, err := c.ytdlpVideo(ctx, "ytsearch1:"+query)
+		if err != nil {
+			return "", err
+		}
+		c.videos.set(video.ID, videoResult{video: video})
+		id = video.ID
+	} else if err != nil {
+		return "", err
+	}
+
+	c.searches.set(key, id)
+	return id, nil
+}
+
+func (c *Client) apiSearch(ctx context.Context, query string) (id string, err error) {
+	res, err := c.service.Search.List([]string{"id", "snippet"}).Q(query).MaxResults(5).Context(ctx).Do()
+	if err != nil {
+		return "", c.apiError(err)
+	}
+
+	for _, item := range res.Items {
+		if item.Id != nil && item.Id.Kind == "youtube#video" {
+			return item.Id.VideoId, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+// ytdlpVideo reads the first video of the url with yt-dlp
+func (c *Client) ytdlpVideo(ctx context.Context, url string) (video Video, err error) {
+	list, err := c.ytdlp(ctx, url, 0, 1)
+	if err != nil {
+		return video, err
+	}
+	if len(list) == 0 {
+		return video, ErrNotFound
+	}
+	return list[0], nil
+}
+
+func (c *Client) ytdlp(ctx context.Context, url string, first int, last int) (list []Video, err error) {
+	log.Println("Youtube quota of the day exceeded, using yt-dlp")
+	c.fallbacks.Add(1)
+
+	list, err = YtdlpVideos(ctx, url, first, last)
+	if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrUnavailable) && !errors.Is(err, ErrNetwork) {
+		return list, fmt.Errorf("%w, and yt-dlp failed: %w", ErrQuota, err)
+	}
+	return list, err
+}
+
+// apiError classifies the error of a call of the api.
+// If youtube says the quota is over, the budget of the day is used up, so the next calls go to yt-dlp
+func (c *Client) apiError(err error) error {
+	err = apiError(err)
+	if errors.Is(err, ErrQuota) {
+		c.quota.Exhaust()
+	}
+	return err
+}
+
+// ClientStats are the numbers of the client
+type ClientStats struct {
+	Quota     QuotaStats
+	Cached    int   // videos, playlists and searches cached
+	CacheHits int64 // calls answered by the cache
+	Fallbacks int64 // calls made with yt-dlp because the quota was over
+}
+
+func (c *Client) Stats() (stats ClientStats) {
+	stats.Quota = c.quota.Stats()
+
+	for _, cacheStats := range []func() (int, int64){c.videos.stats, c.playlists.stats, c.searches.stats} {
+		entries, hits := cacheStats()
+		stats.Cached += entries
+		stats.CacheHits += hits
+	}
+
+	stats.Fallbacks = c.fallbacks.Load()
+	return stats
+}
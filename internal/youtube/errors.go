@@ -0,0 +1,92 @@
+package youtube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// The errors returned by the client. They wrap the original error, check them with errors.Is
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrPrivate     = errors.New("private")
+	ErrUnavailable = errors.New("unavailable")
+	ErrQuota       = errors.New("youtube quota of the day exceeded")
+	ErrNetwork     = errors.New("network error")
+)
+
+// UnavailableError is returned for a video that exists but can't be played
+type UnavailableError struct {
+	Reason string
+}
+
+func (e *UnavailableError) Error() string {
+	return "video unavailable: " + e.Reason
+}
+
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable || (target == ErrPrivate && e.Reason == "private")
+}
+
+// apiError classifies the error of a call of the api
+func apiError(err error) error {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		reason := ""
+		if len(apiErr.Errors) > 0 {
+			reason = apiErr.Errors[0].Reason
+		}
+
+		switch {
+		case reason == "quotaExceeded" || reason == "dailyLimitExceeded" || reason == "rateLimitExceeded":
+			return fmt.Errorf("%w: %w", ErrQuota, err)
+		case apiErr.Code == http.StatusNotFound:
+			return fmt.Errorf("%w: %w", ErrNotFound, err)
+		case reason == "playlistItemsNotAccessible" || reason == "playlistForbidden" || reason == "forbidden":
+			return fmt.Errorf("%w: %w", ErrPrivate, err)
+		case apiErr.Code >= 500:
+			return fmt.Errorf("%w: %w", ErrNetwork, err)
+		}
+		return err
+	}
+
+	return networkError(err)
+}
+
+// networkError marks the errors of the connection as ErrNetwork
+func networkError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrNetwork, err)
+	}
+	return err
+}
+
+// ytdlpError classifies the error of yt-dlp from what it printed
+func ytdlpError(err error) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return networkError(err)
+	}
+
+	stderr := string(exitErr.Stderr)
+	switch {
+	case strings.Contains(stderr, "Private video") || strings.Contains(stderr, "private playlist"):
+		return &UnavailableError{Reason: "private"}
+	case strings.Contains(stderr, "not available in your country") || strings.Contains(stderr, "blocked it in your country"):
+		return &UnavailableError{Reason: "blocked in this country"}
+	case strings.Contains(stderr, "Sign in to confirm your age"):
+		return &UnavailableError{Reason: "age restricted"}
+	case strings.Contains(stderr, "Video unavailable") || strings.Contains(stderr, "does not exist") || strings.Contains(stderr, "Incomplete"):
+		return fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSpace(stderr))
+	case strings.Contains(stderr, "Unable to download") || strings.Contains(stderr, "timed out") || strings.Contains(stderr, "name resolution"):
+		return fmt.Errorf("%w: %s", ErrNetwork, strings.TrimSpace(stderr))
+	}
+	return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr))
+}
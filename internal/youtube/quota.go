@@ -0,0 +1,130 @@
+package youtube
+
+import (
+	"sync"
+	"time"
+)
+
+// costs of the api calls, in quota units. See https://developers.google.com/youtube/v3/determine_quota_cost
+const (
+	CostVideos        = 1
+	CostPlaylistItems = 1
+	CostSearch        = 100
+)
+
+// quotaLocation is where the day of the quota starts: the quota resets at midnight Pacific Time
+var quotaLocation = func() *time.Location {
+	location, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}()
+
+// Quota counts the units of the youtube api spent in the day, against a budget
+type Quota struct {
+	lock   sync.Mutex
+	budget int
+	day    string
+	used   int
+	calls  map[string]int // api -> calls made in the day
+	denied int            // calls not made because of the budget
+}
+
+func NewQuota(budget int) *Quota {
+	return &Quota{budget: budget, calls: map[string]int{}}
+}
+
+// Spend takes the units from the budget of the day, returns false if they don't fit
+func (q *Quota) Spend(api string, units int) (res bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.rollover()
+	if q.used+units > q.budget {
+		q.denied++
+		return false
+	}
+
+	q.used += units
+	q.calls[api]++
+	return true
+}
+
+// rollover starts a new day if needed. It must be called with the lock
+func (q *Quota) rollover() {
+	day := time.Now().In(quotaLocation).Format(time.DateOnly)
+	if q.day != day {
+		q.day = day
+		q.used = 0
+		q.denied = 0
+		q.calls = map[string]int{}
+	}
+}
+
+// Exhaust uses up the budget of the day, when youtube says the quota is over before the budget
+func (q *Quota) Exhaust() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.rollover()
+	q.used = max(q.used, q.budget)
+}
+
+func (q *Quota) SetBudget(budget int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.budget = budget
+}
+
+// QuotaState is the quota spent in the day, saved between restarts
+type QuotaState struct {
+	Day  string `json:"day"`
+	Used int    `json:"used"`
+}
+
+func (q *Quota) State() QuotaState {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.rollover()
+	return QuotaState{Day: q.day, Used: q.used}
+}
+
+// Restore sets the quota spent, if it's of the current day
+func (q *Quota) Restore(state QuotaState) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.rollover()
+	if state.Day == q.day {
+		q.used = max(q.used, state.Used)
+	}
+}
+
+// QuotaStats are the numbers of the quota of the day
+type QuotaStats struct {
+	Day    string
+	Used   int
+	Budget int
+	Calls  map[string]int
+	Denied int
+	Reset  time.Time // when the next day starts
+}
+
+func (q *Quota) Stats() QuotaStats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.rollover()
+	calls := make(map[string]int, len(q.calls))
+	for api, n := range q.calls {
+		calls[api] = n
+	}
+
+	now := time.Now().In(quotaLocation)
+	reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, quotaLocation)
+
+	return QuotaStats{Day: q.day, Used: q.used, Budget: q.budget, Calls: calls, Denied: q.denied, Reset: reset}
+}
@@ -0,0 +1,50 @@
+package youtube
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// YtdlpVideos reads the videos of the url with yt-dlp, without the api: a video, a playlist, a mix or a search like "ytsearch1:query".
+// Only the videos from the position `first` (from 0) to `last` excluded are read, 0 for the end.
+// The errors are classified like the ones of the client
+func YtdlpVideos(ctx context.Context, url string, first int, last int) (list []Video, err error) {
+	args := []string{"--flat-playlist", "--print", "%(id)s\t%(title)s\t%(channel)s\t%(duration_string)s"}
+	if first > 0 || last > 0 {
+		items := strconv.Itoa(first+1) + ":"
+		if last > 0 {
+			items += strconv.Itoa(last)
+		}
+		args = append(args, "--playlist-items", items)
+	}
+	args = append(args, url)
+
+	out, err := exec.CommandContext(ctx, "yt-dlp", args...).Output()
+	if err != nil {
+		return list, ytdlpError(err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 4 {
+			continue
+		}
+		if fields[3] == "NA" {
+			fields[3] = ""
+		}
+		list = append(list, Video{
+			ID:        fields[0],
+			Title:     fields[1],
+			Author:    fields[2],
+			Duration:  fields[3],
+			Thumbnail: "https://i.ytimg.com/vi/" + fields[0] + "/default.jpg",
+		})
+	}
+
+	return list, nil
+}